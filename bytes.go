@@ -0,0 +1,44 @@
+package goftfy
+
+import "unsafe"
+
+// FixBytes applies FixWithOptions to b without converting it to a string
+// and back, for []byte from network reads and similar hot paths. Clean input
+// is returned as-is. Otherwise, when the fixed text is no longer than b (the
+// usual case, since most fixes shrink text) it is written over b and a
+// prefix of b is returned; only longer results are allocated. Callers that
+// need the original bytes afterwards must copy them first. The input is
+// viewed as a string without copying; the pipeline never retains its input,
+// which keeps that view safe for the duration of the call.
+func FixBytes(b []byte, opts Options) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	text := unsafe.String(unsafe.SliceData(b), len(b))
+	fixed := FixWithOptions(text, opts)
+	if fixed == text {
+		return b
+	}
+	if len(fixed) <= len(b) {
+		// fixed may share memory with b; copy handles the overlap.
+		return b[:copy(b, fixed)]
+	}
+	return []byte(fixed)
+}
+
+// FixByteSlices applies the default fixes to every row, for records read as
+// [][]byte (such as a column from a columnar file format), with FixBytes.
+// Rows that need no fixing are returned as-is rather than copied, rows whose
+// fix fits are fixed in place, and a nil row stays nil while an empty row
+// stays empty.
+func FixByteSlices(rows [][]byte) [][]byte {
+	if rows == nil {
+		return nil
+	}
+	opts := DefaultOptions()
+	result := make([][]byte, len(rows))
+	for i, row := range rows {
+		result[i] = FixBytes(row, opts)
+	}
+	return result
+}
@@ -1,6 +1,12 @@
 package goftfy
 
-import "strings"
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
 
 // CharInfo holds information about a Unicode character's context.
 type CharInfo struct {
@@ -8,21 +14,39 @@ type CharInfo struct {
 	Category      string
 	IsProblematic bool
 	Suggestion    rune
+	// ByteOffset is the character's offset in bytes into the analysed
+	// string, so text[ByteOffset:] starts with it. An invalid byte is
+	// reported as a U+FFFD of its own.
+	ByteOffset int
+	// RuneIndex is the character's index among the runes of the string.
+	RuneIndex int
 }
 
 // AnalyzeString returns per-character analysis of potentially problematic chars.
+// Emoji components (variation selectors, zero-width joiners and skin-tone
+// modifiers) are only reported when they appear outside a well-formed emoji
+// sequence.
 func AnalyzeString(text string) []CharInfo {
 	var result []CharInfo
-	for _, r := range text {
-		info := analyzeRune(r)
+	rs := []rune(text)
+	i := 0
+	// Ranging over text yields the same runes as the conversion above,
+	// including one U+FFFD per invalid byte, along with their byte offsets.
+	for offset := range text {
+		info := analyzeRune(rs, i)
 		if info.IsProblematic {
+			info.ByteOffset, info.RuneIndex = offset, i
 			result = append(result, info)
 		}
+		i++
 	}
 	return result
 }
 
-func analyzeRune(r rune) CharInfo {
+// analyzeRune classifies rs[i], looking at its neighbours where the verdict
+// depends on context.
+func analyzeRune(rs []rune, i int) CharInfo {
+	r := rs[i]
 	info := CharInfo{Rune: r}
 	switch {
 	case r >= 0xD800 && r <= 0xDFFF:
@@ -38,21 +62,78 @@ func analyzeRune(r rune) CharInfo {
 	case r == '\uFFFD':
 		info.Category = "replacement_char"
 		info.IsProblematic = true
-	case isMojibakeChar(r):
+	case isMojibakeLead(rs, i):
 		info.Category = "likely_mojibake"
 		info.IsProblematic = true
+	case r == '\u200D':
+		info.Category = "zero_width_joiner"
+		info.IsProblematic = !zwjInContext(rs, i)
+	case r == '\uFE0E' || r == '\uFE0F':
+		info.Category = "variation_selector"
+		info.IsProblematic = i == 0 || !isVariationBase(rs[i-1])
+	case isSkinToneModifier(r):
+		info.Category = "emoji_modifier"
+		info.IsProblematic = i == 0 || !isEmoji(rs[i-1])
 	default:
 		info.Category = "ok"
 	}
 	return info
 }
 
-func isMojibakeChar(r rune) bool {
-	mojibakeIndicators := []rune{'Ã', 'â', 'Â', 'ï', 'Å', 'Ä', 'Ö', 'Ü'}
-	for _, m := range mojibakeIndicators {
-		if r == m {
-			return true
-		}
+// zwjInContext reports whether the zero-width joiner at rs[i] is part of an
+// emoji sequence or joins characters of a script that relies on it (Indic
+// conjuncts, Arabic joining forms).
+func zwjInContext(rs []rune, i int) bool {
+	if i == 0 || i+1 >= len(rs) {
+		return false
+	}
+	prev, next := rs[i-1], rs[i+1]
+	if (isEmoji(prev) || prev == '\uFE0F') && isEmoji(next) {
+		return true
+	}
+	if unicode.Is(unicode.Latin, prev) || unicode.Is(unicode.Common, prev) {
+		return false
+	}
+	return unicode.IsLetter(prev) || unicode.IsMark(prev)
+}
+
+// isVariationBase reports whether r may legitimately be followed by a text or
+// emoji presentation selector.
+func isVariationBase(r rune) bool {
+	return isEmoji(r) || unicode.IsSymbol(r) || unicode.IsPunct(r) ||
+		(r >= '0' && r <= '9') || r == '#' || r == '*'
+}
+
+func isSkinToneModifier(r rune) bool {
+	return r >= 0x1F3FB && r <= 0x1F3FF
+}
+
+// isEmoji reports whether r falls in one of the blocks that hold emoji.
+// It is deliberately broad; it only decides whether emoji components next
+// to r are in a plausible position.
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF:
+		return true
+	case r >= 0x2600 && r <= 0x27BF:
+		return true
+	case r >= 0x2300 && r <= 0x23FF:
+		return true
+	case r >= 0x2B00 && r <= 0x2BFF:
+		return true
+	}
+	return false
+}
+
+// isMojibakeLead reports whether rs[i] looks like the lead byte of a UTF-8
+// sequence misread as Latin-1 or Windows-1252. Letters such as 'Å', 'Ä',
+// 'Ö' and 'Ü' are everyday Swedish and German, so they only count when
+// the next character reads back as a continuation byte (0x80–0xBF), as in
+// "Ã©" or "â€™".
+func isMojibakeLead(rs []rune, i int) bool {
+	switch rs[i] {
+	case 'Ã', 'â', 'Â', 'ï', 'Å', 'Ä', 'Ö', 'Ü':
+		return i+1 < len(rs) && isContinuationChar(rs[i+1])
 	}
 	return false
 }
@@ -62,12 +143,190 @@ func HasReplacementChars(text string) bool {
 	return strings.Contains(text, "\uFFFD")
 }
 
-// HasSurrogates reports whether the string contains unpaired UTF-16 surrogates.
-func HasSurrogates(text string) bool {
+// CountReplacementChars returns the number of U+FFFD replacement characters
+// in text. Like HasReplacementChars it counts encoded U+FFFD characters
+// only; invalid UTF-8 bytes are not replacement characters yet.
+func CountReplacementChars(text string) int {
+	return strings.Count(text, "\uFFFD")
+}
+
+// ReplacementCharOffsets returns the byte offset of every U+FFFD
+// replacement character in text, in order.
+func ReplacementCharOffsets(text string) []int {
+	var offsets []int
+	for i := 0; ; {
+		j := strings.Index(text[i:], "\uFFFD")
+		if j < 0 {
+			return offsets
+		}
+		offsets = append(offsets, i+j)
+		i += j + len("\uFFFD")
+	}
+}
+
+// CountLostBytes estimates how many bytes of the original data were lost to
+// U+FFFD replacement characters. It is a heuristic for data-quality metrics:
+//
+//   - a lone U+FFFD between ASCII characters (or at the edge of the text)
+//     counts as one byte, the classic single invalid byte;
+//   - a lone U+FFFD next to other non-ASCII text counts as two bytes, since
+//     decoders that replace maximal subparts fold a truncated multi-byte
+//     sequence into a single replacement character;
+//   - a run of n U+FFFDs counts as n bytes, since byte-wise decoders (Go's
+//     included) emit one replacement character per invalid byte.
+func CountLostBytes(text string) int {
+	rs := []rune(text)
+	lost := 0
+	for i := 0; i < len(rs); {
+		if rs[i] != unicode.ReplacementChar {
+			i++
+			continue
+		}
+		j := i
+		for j < len(rs) && rs[j] == unicode.ReplacementChar {
+			j++
+		}
+		switch {
+		case j-i > 1:
+			lost += j - i
+		case (i > 0 && rs[i-1] > unicode.MaxASCII) || (j < len(rs) && rs[j] > unicode.MaxASCII):
+			lost += 2
+		default:
+			lost++
+		}
+		i = j
+	}
+	return lost
+}
+
+// Regressed reports whether fixed looks more corrupted than original, so that
+// callers can reject a fix that made things worse. It is true if fixed has
+// more U+FFFD replacement characters, more mojibake sequences (see
+// ScanPatterns), or a higher share of non-ASCII runes without gaining
+// non-ASCII letters, which is how text decoded with the wrong charset looks.
+// Punctuation is left out of the last check, so curly quotes, dashes and
+// ellipses introduced on purpose do not count as a regression.
+func Regressed(original, fixed string) bool {
+	if strings.Count(fixed, "\uFFFD") > strings.Count(original, "\uFFFD") {
+		return true
+	}
+	if mojibakeCount(fixed) > mojibakeCount(original) {
+		return true
+	}
+	origRunes, origOther, origLetters := nonASCIIProfile(original)
+	fixedRunes, fixedOther, fixedLetters := nonASCIIProfile(fixed)
+	// fixedOther/fixedRunes > origOther/origRunes, without dividing.
+	return fixedOther*origRunes > origOther*fixedRunes && fixedLetters <= origLetters
+}
+
+// mojibakeCount returns the number of mojibake sequences ScanPatterns finds.
+func mojibakeCount(text string) int {
+	n := 0
+	for _, m := range ScanPatterns(text) {
+		n += m.Count
+	}
+	return n
+}
+
+// nonASCIIProfile counts the runes in text, and among its non-ASCII runes
+// those that are letters and those that are neither letters nor punctuation.
+func nonASCIIProfile(text string) (runes, other, letters int) {
 	for _, r := range text {
-		if r >= 0xD800 && r <= 0xDFFF {
+		runes++
+		switch {
+		case r <= unicode.MaxASCII || unicode.IsPunct(r):
+		case unicode.IsLetter(r):
+			letters++
+		default:
+			other++
+		}
+	}
+	return runes, other, letters
+}
+
+// HasMixedNormalization reports whether text contains both a precomposed
+// character that has a canonical decomposition ("é" as U+00E9) and a
+// decomposed sequence that NFC would compose ("e" + U+0301). Such mixtures
+// usually mean records from different sources were merged. Text in a single
+// form, or with no composable characters at all, reports false.
+func HasMixedNormalization(text string) bool {
+	composed, decomposed := false, false
+	for _, g := range graphemes(text) {
+		if len(g) == 1 {
+			continue
+		}
+		n := utf8.RuneCountInString(g)
+		if utf8.RuneCountInString(norm.NFD.String(g)) > n {
+			composed = true
+		}
+		if utf8.RuneCountInString(norm.NFC.String(g)) < n {
+			decomposed = true
+		}
+		if composed && decomposed {
 			return true
 		}
 	}
 	return false
 }
+
+// HasSurrogates reports whether the string contains UTF-16 surrogates, which
+// can only appear as CESU-8/WTF-8 byte sequences in a Go string.
+func HasSurrogates(text string) bool {
+	for i := strings.IndexByte(text, 0xED); i >= 0; {
+		if _, ok := surrogateAt(text, i); ok {
+			return true
+		}
+		next := strings.IndexByte(text[i+1:], 0xED)
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+	return false
+}
+
+// Grade rates how clean text is on a school scale, for data-quality
+// scorecards. Each region Fix would change counts as one problem per rune of
+// its longer side, broken or fixed, so "Ã©" becoming "é" counts two, and every
+// byte lost to U+FFFD (see CountLostBytes) counts as four, since that damage
+// cannot be repaired. The grade follows from the problem density, problems
+// per rune:
+//
+//	A  no problems
+//	B  up to 2%
+//	C  up to 5%
+//	D  up to 15%
+//	F  above 15%, or more than 5% of runes lost to replacement characters
+//
+// Text that Fix leaves alone and that has lost no bytes is graded without
+// any further work.
+func Grade(text string) string {
+	runes := utf8.RuneCountInString(text)
+	if runes == 0 {
+		return "A"
+	}
+	lost := CountLostBytes(text)
+	fixed := Fix(text)
+	if fixed == text && lost == 0 {
+		return "A"
+	}
+	problems := 4 * lost
+	if fixed != text {
+		for _, h := range diffHunks([]rune(text), []rune(fixed)) {
+			problems += max(h.aEnd-h.aStart, h.bEnd-h.bStart)
+		}
+	}
+	density := float64(problems) / float64(runes)
+	switch {
+	case problems == 0:
+		return "A"
+	case density > 0.15 || float64(lost)/float64(runes) > 0.05:
+		return "F"
+	case density > 0.05:
+		return "D"
+	case density > 0.02:
+		return "C"
+	default:
+		return "B"
+	}
+}
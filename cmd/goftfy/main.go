@@ -0,0 +1,171 @@
+// Command goftfy fixes mojibake and other text problems in shell pipelines.
+//
+// Usage:
+//
+//	goftfy [flags] [file ...]
+//
+// It reads the named files, or standard input when there are none, fixes
+// each with the options the flags select (goftfy.DefaultOptions otherwise)
+// and writes the result to standard output:
+//
+//	goftfy < in.txt > out.txt
+//	goftfy -curly-quotes -no-html -norm=NFKC notes.txt
+//	goftfy -explain data.csv > fixed.csv
+//
+// Run goftfy -h for the full list of flags.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/njchilds90/goftfy"
+)
+
+func main() {
+	opts, explain := parseFlags(flag.CommandLine, os.Args[1:])
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	status := 0
+	for _, name := range inputs {
+		if err := fixFile(name, opts, explain, os.Stdout, os.Stderr); err != nil {
+			fmt.Fprintf(os.Stderr, "goftfy: %v\n", err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+// parseFlags defines the command-line flags on fs, parses args and returns
+// the options they select and whether -explain was given. Stages that are on
+// by default are turned off with -no-* flags; the others are turned on by
+// name.
+func parseFlags(fs *flag.FlagSet, args []string) (goftfy.Options, bool) {
+	opts := goftfy.DefaultOptions()
+	type negated struct{ field, flag *bool }
+	var offs []negated
+	off := func(field *bool, name, usage string) {
+		offs = append(offs, negated{field, fs.Bool(name, false, usage)})
+	}
+	on := func(field *bool, name, usage string) {
+		fs.BoolVar(field, name, *field, usage)
+	}
+
+	off(&opts.FixEncoding, "no-encoding", "do not fix mojibake")
+	off(&opts.FixHTMLEntities, "no-html", "do not decode HTML entities")
+	off(&opts.FixLineBreaks, "no-line-breaks", "do not normalize line breaks")
+	off(&opts.FixSurrogates, "no-surrogates", "do not fix encoded surrogates")
+	off(&opts.FixControlChars, "no-control", "do not strip control characters")
+	off(&opts.StripBOM, "no-bom", "do not strip a leading byte-order mark")
+
+	on(&opts.FixUTF7, "utf7", "decode UTF-7 shift sequences")
+	on(&opts.FixPercentEncoding, "percent", "decode percent-encoded UTF-8")
+	on(&opts.StrictAmpersand, "strict-amp", "only decode entities ending in ';'")
+	on(&opts.CaseInsensitiveEntities, "ci-entities", "decode entities with nonstandard casing")
+	on(&opts.FixCurlyQuotes, "curly-quotes", "straighten curly quotes")
+	on(&opts.SmartenQuotes, "smart-quotes", "curl straight quotes")
+	on(&opts.NormalizeEllipsis, "ellipsis", "normalize ellipses")
+	on(&opts.CanonicalOrdering, "canonical-order", "sort combining marks by class")
+	on(&opts.RemoveTerminalEscapes, "terminal-escapes", "strip ANSI escape sequences")
+	on(&opts.RemoveZeroWidth, "zero-width", "strip zero-width characters")
+	on(&opts.CollapseWhitespace, "collapse-ws", "collapse runs of horizontal whitespace")
+	on(&opts.TrimTrailingSpace, "trim-trailing", "strip whitespace at the end of every line")
+	on(&opts.EnsureFinalNewline, "final-newline", "end the text with exactly one newline")
+	on(&opts.NumericDashes, "numeric-dashes", "turn dashes before digits into '-'")
+	on(&opts.TidyPunctuationSpacing, "tidy-punct", "tidy spacing around em dashes and sentence ends")
+	on(&opts.FoldRomanNumerals, "roman", "fold Roman numeral characters to ASCII")
+	on(&opts.TabIsDelimiter, "tabs", "never alter tab characters")
+
+	fs.StringVar(&opts.NormalizationForm, "norm", opts.NormalizationForm, "Unicode normalization: NFC, NFD, NFKC, NFKD or empty")
+	fs.StringVar(&opts.SourceCharset, "charset", opts.SourceCharset, "charset the text was misread as: utf-16le or utf-16be")
+	fs.StringVar(&opts.ControlCharReplacement, "control-repl", opts.ControlCharReplacement, "write `s` in place of each stripped control character")
+	fs.StringVar(&opts.SourceEncoding, "source-encoding", opts.SourceEncoding, "true encoding of the misread bytes, such as koi8-r, skipping detection")
+	fs.StringVar(&opts.LineEndingStyle, "eol", opts.LineEndingStyle, "line ending style: lf, crlf or cr")
+	fs.IntVar(&opts.MaxEntityExpansions, "max-entities", opts.MaxEntityExpansions, "decode at most `n` entities (0 = no limit)")
+	fs.IntVar(&opts.MaxDecodePasses, "max-passes", opts.MaxDecodePasses, "peel at most `n` mojibake layers (0 = default)")
+	fs.Func("charset-pref", "comma-separated charsets to reverse mojibake from, in order", func(s string) error {
+		opts.CharsetPreference = strings.Split(s, ",")
+		return nil
+	})
+	fs.Func("quote-style", "quotes -curly-quotes straightens: ascii, guillemets or english", func(s string) error {
+		styles := map[string]goftfy.QuoteStyle{
+			"ascii":      goftfy.QuoteASCII,
+			"guillemets": goftfy.QuotePreserveGuillemets,
+			"english":    goftfy.QuoteCurlyToStraight,
+		}
+		style, ok := styles[s]
+		if !ok {
+			return fmt.Errorf("unknown quote style %q", s)
+		}
+		opts.QuoteStyle = style
+		return nil
+	})
+	fs.Func("ellipsis-style", "form -ellipsis produces: char or dots", func(s string) error {
+		switch s {
+		case "char":
+			opts.EllipsisStyle = goftfy.EllipsisChar
+		case "dots":
+			opts.EllipsisStyle = goftfy.EllipsisDots
+		default:
+			return fmt.Errorf("unknown ellipsis style %q", s)
+		}
+		return nil
+	})
+	fs.Func("entities", "entity recognition: lenient or strict", func(s string) error {
+		switch s {
+		case "lenient":
+			opts.HTMLEntityMode = goftfy.HTMLLenient
+		case "strict":
+			opts.HTMLEntityMode = goftfy.HTMLStrict
+		default:
+			return fmt.Errorf("unknown entity mode %q", s)
+		}
+		return nil
+	})
+	explain := fs.Bool("explain", false, "report each change on standard error")
+
+	fs.Parse(args)
+	for _, n := range offs {
+		if *n.flag {
+			*n.field = false
+		}
+	}
+	return opts, *explain
+}
+
+// fixFile fixes the named file, or standard input for "-", writing the
+// result to out and, with explain, a report of the changes to report.
+// Without explain the input is streamed through goftfy.FixReader rather than
+// read into memory.
+func fixFile(name string, opts goftfy.Options, explain bool, out, report io.Writer) error {
+	var r io.Reader = os.Stdin
+	label := "stdin"
+	if name != "-" {
+		label = name
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if !explain {
+		_, err := io.Copy(out, goftfy.FixReader(r, opts))
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	fixed, changes := goftfy.ExplainWithOptions(string(data), opts)
+	for _, c := range changes {
+		fmt.Fprintf(report, "%s: %s: %q -> %q (x%d)\n", label, c.Stage, c.Before, c.After, c.Count)
+	}
+	_, err = io.WriteString(out, fixed)
+	return err
+}
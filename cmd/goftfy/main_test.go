@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/njchilds90/goftfy"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		args    []string
+		explain bool
+		edit    func(*goftfy.Options)
+	}{
+		{nil, false, func(*goftfy.Options) {}},
+		{[]string{"-explain"}, true, func(*goftfy.Options) {}},
+		{[]string{"-no-encoding", "-no-html", "-no-bom"}, false, func(o *goftfy.Options) {
+			o.FixEncoding, o.FixHTMLEntities, o.StripBOM = false, false, false
+		}},
+		{[]string{"-curly-quotes", "-ellipsis", "-tabs", "-final-newline"}, false, func(o *goftfy.Options) {
+			o.FixCurlyQuotes, o.NormalizeEllipsis, o.TabIsDelimiter, o.EnsureFinalNewline = true, true, true, true
+		}},
+		{[]string{"-norm=NFKC", "-eol=crlf", "-max-entities=3", "-control-repl=?"}, false, func(o *goftfy.Options) {
+			o.NormalizationForm, o.LineEndingStyle, o.MaxEntityExpansions, o.ControlCharReplacement = "NFKC", "crlf", 3, "?"
+		}},
+		{[]string{"-charset-pref=windows-1252,iso-8859-1", "-quote-style=guillemets", "-ellipsis-style=dots", "-entities=strict"}, false, func(o *goftfy.Options) {
+			o.CharsetPreference = []string{"windows-1252", "iso-8859-1"}
+			o.QuoteStyle = goftfy.QuotePreserveGuillemets
+			o.EllipsisStyle = goftfy.EllipsisDots
+			o.HTMLEntityMode = goftfy.HTMLStrict
+		}},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("goftfy", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		opts, explain := parseFlags(fs, tt.args)
+		want := goftfy.DefaultOptions()
+		tt.edit(&want)
+		if !reflect.DeepEqual(opts, want) || explain != tt.explain {
+			t.Errorf("parseFlags(%q) = %+v, %v, want %+v, %v", tt.args, opts, explain, want, tt.explain)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package goftfy
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInvalidUTF8 is returned by DetectEncoding for input that is not valid
+// UTF-8, which has to be repaired (see FixSurrogates) before its characters
+// can be analysed.
+var ErrInvalidUTF8 = errors.New("goftfy: text is not valid UTF-8")
+
+// DetectionResult is DetectEncoding's verdict on a string.
+type DetectionResult struct {
+	// Encoding is the charset the text appears to have been misread as:
+	// "iso-8859-1", "windows-1252", "windows-1251", "iso-8859-7",
+	// "windows-1253" or "utf-16". It is "utf-8" when the text
+	// looks correctly decoded.
+	Encoding string
+	// Confidence is how likely Encoding is right, from 0 to 1.
+	Confidence float64
+	// FixRecommended reports whether running Fix is expected to repair the
+	// encoding.
+	FixRecommended bool
+}
+
+// DetectEncoding estimates whether text is mojibake and, if so, which charset
+// its UTF-8 bytes were misread as. It combines the looksLikeMojibake
+// heuristics with a byte-frequency check: the share of non-ASCII characters
+// that, read back as Latin-1 or Windows-1252 bytes, form valid UTF-8
+// sequences. Pure ASCII returns straight away, so the check is cheap enough
+// to skip Fix on clean text in a hot loop.
+func DetectEncoding(text string) (DetectionResult, error) {
+	if !utf8.ValidString(text) {
+		return DetectionResult{}, ErrInvalidUTF8
+	}
+	if isASCII(text) {
+		return DetectionResult{Encoding: "utf-8", Confidence: 1}, nil
+	}
+	if !looksLikeMojibake(text) {
+		if _, ok := detectUTF16Mojibake(text); ok {
+			return DetectionResult{Encoding: "utf-16", Confidence: 0.9, FixRecommended: true}, nil
+		}
+		return DetectionResult{Encoding: "utf-8", Confidence: confidence(text)}, nil
+	}
+
+	rs := []rune(text)
+	nonASCII, covered, cp1252Only := 0, 0, false
+	foreign, foreignCovered := "", 0
+	for i := 0; i < len(rs); {
+		if i+1 < len(rs) {
+			if cs := foreignMojibakeAt(rs[i], rs[i+1]); cs != "" {
+				foreign = cs
+				foreignCovered += 2
+			}
+		}
+		if n, _ := mojibakeAt(rs, i); n > 0 {
+			for _, r := range rs[i : i+n] {
+				cp1252Only = cp1252Only || r > 0xFF
+			}
+			nonASCII += n
+			covered += n
+			i += n
+			continue
+		}
+		if rs[i] > unicode.MaxASCII {
+			nonASCII++
+		}
+		i++
+	}
+	res := DetectionResult{Encoding: "iso-8859-1"}
+	if cp1252Only {
+		res.Encoding = "windows-1252"
+	}
+	if foreignCovered > covered {
+		res.Encoding, covered = foreign, min(foreignCovered, nonASCII)
+	}
+	if nonASCII > 0 {
+		res.Confidence = float64(covered) / float64(nonASCII)
+	}
+	res.FixRecommended = fixEncoding(text, DefaultOptions()) != text
+	if !res.FixRecommended {
+		res.Confidence /= 2
+	}
+	return res, nil
+}
+
+// encodingTags maps DetectEncoding's charset names to Classify tags.
+var encodingTags = map[string]string{
+	"iso-8859-1":   "utf8-as-latin1",
+	"windows-1252": "utf8-as-cp1252",
+	"windows-1251": "utf8-as-cp1251",
+	"iso-8859-7":   "utf8-as-iso8859-7",
+	"windows-1253": "utf8-as-cp1253",
+	"utf-16":       "utf8-as-utf16",
+}
+
+// Classify tags the kinds of corruption found in text, for routing records
+// by what went wrong rather than just whether something did:
+//
+//   - "utf8-as-latin1", "utf8-as-cp1252", "utf8-as-cp1251",
+//     "utf8-as-iso8859-7", "utf8-as-cp1253", "utf8-as-utf16": UTF-8 misread
+//     as that charset, as DetectEncoding judges it
+//   - "double-encoded": mojibake of mojibake (see DetectLayers)
+//   - "unpaired-surrogates": encoded UTF-16 surrogates without their partner
+//   - "c1-controls": C1 control characters (U+0080–U+009F)
+//
+// Tags come in the order above. Clean text returns an empty slice.
+func Classify(text string) []string {
+	var tags []string
+	if det, err := DetectEncoding(text); err == nil && (det.FixRecommended || det.Confidence > 0) {
+		if tag, ok := encodingTags[det.Encoding]; ok {
+			tags = append(tags, tag)
+		}
+	}
+	for depth := range DetectLayers(text) {
+		if depth >= 2 {
+			tags = append(tags, "double-encoded")
+			break
+		}
+	}
+	if hasUnpairedSurrogate(text) {
+		tags = append(tags, "unpaired-surrogates")
+	}
+	if strings.ContainsFunc(text, func(r rune) bool { return r >= 0x80 && r <= 0x9F }) {
+		tags = append(tags, "c1-controls")
+	}
+	return tags
+}
+
+// hasUnpairedSurrogate reports whether text holds an encoded surrogate that
+// fixSurrogates could not join with a neighbour.
+func hasUnpairedSurrogate(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if _, ok := surrogatePairAt(text, i); ok {
+			i += 5
+			continue
+		}
+		if _, ok := surrogateAt(text, i); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isASCII reports whether text contains only ASCII bytes.
+func isASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Result bundles everything Inspect reports about a string.
+type Result struct {
+	// Fixed is the text fixed with the options given, as FixWithOptions
+	// returns it.
+	Fixed string
+	// Changed reports whether Fixed differs from the input.
+	Changed bool
+	// Stages names the pipeline stages that changed the text, in order.
+	Stages []string
+	// Problems are the suspected corruptions Lint finds in the input.
+	Problems []Problem
+	// DetectedEncoding and Confidence are DetectEncoding's verdict on the
+	// input. DetectedEncoding is empty for invalid UTF-8.
+	DetectedEncoding string
+	Confidence       float64
+}
+
+// Inspect fixes text with opts and reports everything known about it in
+// one call, for dashboards: the fixed text, which stages changed it, the
+// problems Lint finds and the encoding DetectEncoding guesses. The fixed
+// text and the stage list come from one fix, run to a fixed point as
+// FixWithOptions does; DetectEncoding still runs the encoding stage on the
+// input again to judge whether fixing is recommended.
+func Inspect(text string, opts Options) Result {
+	fixed, stages := fixTracked(text, opts)
+	res := Result{
+		Fixed:    fixed,
+		Changed:  fixed != text,
+		Stages:   stages,
+		Problems: Lint(text),
+	}
+	if det, err := DetectEncoding(text); err == nil {
+		res.DetectedEncoding, res.Confidence = det.Encoding, det.Confidence
+	}
+	return res
+}
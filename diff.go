@@ -0,0 +1,347 @@
+package goftfy
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// FixDistance returns the rune-level Levenshtein distance between text and
+// its fixed form under opts. Large distances relative to the input length
+// can indicate over-aggressive fixing worth reviewing. Text the fix leaves
+// alone costs no more than the fix itself.
+func FixDistance(text string, opts Options) int {
+	fixed := FixWithOptions(text, opts)
+	if fixed == text {
+		return 0
+	}
+	return editDistance([]rune(text), []rune(fixed))
+}
+
+// editDistance computes the Levenshtein distance between a and b using the
+// standard dynamic-programming recurrence, keeping only two rows in memory.
+// A common prefix and suffix never affect the distance, so they are trimmed
+// first; fixes are local, which leaves the quadratic part small.
+func editDistance(a, b []rune) int {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	if len(a) == 0 {
+		return len(b)
+	}
+	if len(b) == 0 {
+		return len(a)
+	}
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// FixMarkup fixes original under opts and also returns an HTML redline of
+// the changes: removed spans are wrapped in <del> and added spans in <ins>.
+// All text is HTML-escaped, so the markup is safe to render as-is.
+func FixMarkup(original string, opts Options) (string, template.HTML) {
+	fixed := FixWithOptions(original, opts)
+	a, b := []rune(original), []rune(fixed)
+
+	var sb strings.Builder
+	ai := 0
+	for _, h := range diffHunks(a, b) {
+		sb.WriteString(template.HTMLEscapeString(string(a[ai:h.aStart])))
+		if h.aEnd > h.aStart {
+			sb.WriteString("<del>")
+			sb.WriteString(template.HTMLEscapeString(string(a[h.aStart:h.aEnd])))
+			sb.WriteString("</del>")
+		}
+		if h.bEnd > h.bStart {
+			sb.WriteString("<ins>")
+			sb.WriteString(template.HTMLEscapeString(string(b[h.bStart:h.bEnd])))
+			sb.WriteString("</ins>")
+		}
+		ai = h.aEnd
+	}
+	sb.WriteString(template.HTMLEscapeString(string(a[ai:])))
+	return fixed, template.HTML(sb.String())
+}
+
+// unifiedContext is the number of unchanged lines FixUnifiedDiff shows
+// around each change, as diff -u does.
+const unifiedContext = 3
+
+// FixUnifiedDiff fixes original under opts and returns the changes as a
+// line-oriented unified diff ("--- original", "+++ fixed", then @@ hunks
+// with three lines of context), or "" when nothing changed. Changes that are
+// close together share a hunk, so text with many small inline fixes yields a
+// few readable hunks rather than one per line.
+func FixUnifiedDiff(original string, opts Options) string {
+	fixed := FixWithOptions(original, opts)
+	if fixed == original {
+		return ""
+	}
+	a, b := splitDiffLines(original), splitDiffLines(fixed)
+	hunks := diffHunks(a, b)
+
+	var sb strings.Builder
+	sb.WriteString("--- original\n+++ fixed\n")
+	for i := 0; i < len(hunks); {
+		j := i
+		for j+1 < len(hunks) && hunks[j+1].aStart-hunks[j].aEnd <= 2*unifiedContext {
+			j++
+		}
+		aStart := max(hunks[i].aStart-unifiedContext, 0)
+		bStart := hunks[i].bStart - (hunks[i].aStart - aStart)
+		aEnd := min(hunks[j].aEnd+unifiedContext, len(a))
+		bEnd := hunks[j].bEnd + (aEnd - hunks[j].aEnd)
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", unifiedRange(aStart, aEnd), unifiedRange(bStart, bEnd))
+
+		pos := aStart
+		for _, h := range hunks[i : j+1] {
+			for ; pos < h.aStart; pos++ {
+				sb.WriteString(" " + a[pos] + "\n")
+			}
+			for _, line := range a[h.aStart:h.aEnd] {
+				sb.WriteString("-" + line + "\n")
+			}
+			for _, line := range b[h.bStart:h.bEnd] {
+				sb.WriteString("+" + line + "\n")
+			}
+			pos = h.aEnd
+		}
+		for ; pos < aEnd; pos++ {
+			sb.WriteString(" " + a[pos] + "\n")
+		}
+		i = j + 1
+	}
+	return sb.String()
+}
+
+// splitDiffLines splits text into lines for diffing; a final newline does not
+// start an extra empty line.
+func splitDiffLines(text string) []string {
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// unifiedRange formats the 0-based half-open line range [start, end) the way
+// unified diff headers do: 1-based "start,count", with the count omitted when
+// it is 1 and the start naming the preceding line when it is 0.
+func unifiedRange(start, end int) string {
+	switch end - start {
+	case 0:
+		return strconv.Itoa(start) + ",0"
+	case 1:
+		return strconv.Itoa(start + 1)
+	}
+	return strconv.Itoa(start+1) + "," + strconv.Itoa(end-start)
+}
+
+// FixAsScript fixes original under opts and describes every edit as a sed
+// substitution ("s/cafÃ©/café/g"), grouped under a "# ..." comment naming
+// the stage that made it, in the order the stages ran. Each edit is widened
+// to the whitespace-delimited words it touches so that the lines read
+// naturally and are safe to apply on their own; applying the script with
+// GNU sed reproduces the fix for typical input. A stage that changes the
+// text again in a later round (see FixWithOptions) gets a second group.
+// Unchanged text yields "".
+func FixAsScript(original string, opts Options) string {
+	var sb strings.Builder
+	seen := make(map[string]bool)
+	fixRoundsWith(original, opts, opts.Pipeline(), func(st Stage, text string) string {
+		fixed := st.Fn(text)
+		if fixed == text {
+			return text
+		}
+		fmt.Fprintf(&sb, "# %s\n", stageNotes[st.Name])
+		a, b := []rune(text), []rune(fixed)
+		for _, h := range wordHunks(a, b) {
+			line := "s/" + sedPattern(string(a[h.aStart:h.aEnd])) + "/" + sedReplacement(string(b[h.bStart:h.bEnd])) + "/g"
+			if !seen[line] {
+				seen[line] = true
+				sb.WriteString(line + "\n")
+			}
+		}
+		return fixed
+	})
+	return sb.String()
+}
+
+// FixWithTrace fixes text with opts like FixWithOptions and calls trace for
+// every discrete edit a stage makes, in the order the stages ran over all
+// rounds: removed was replaced by added, at byte offset origByteOff of the
+// original text. Offsets always refer to the original, even for edits made
+// after earlier stages shifted the text; text inserted by an earlier stage
+// maps to the offset of the edit that inserted it. Edits are the minimal
+// changed runs of runes (see ExplainWithOptions for word-level changes), and
+// either string may be empty. It is the low-level event stream for custom
+// visualizations and undo logs.
+func FixWithTrace(text string, opts Options, trace func(stage string, origByteOff int, removed, added string)) string {
+	// origin[i] is the original offset byte i of the current text came from.
+	origin := make([]int, len(text)+1)
+	for i := range origin {
+		origin[i] = i
+	}
+	return fixRoundsWith(text, opts, opts.Pipeline(), func(st Stage, text string) string {
+		fixed := st.Fn(text)
+		if fixed == text {
+			return text
+		}
+		aOff, bOff := runeOffsets(text), runeOffsets(fixed)
+		next := make([]int, 0, len(fixed)+1)
+		prev := 0
+		for _, h := range diffHunks([]rune(text), []rune(fixed)) {
+			start := aOff[h.aStart]
+			next = append(next, origin[aOff[prev]:start]...)
+			added := fixed[bOff[h.bStart]:bOff[h.bEnd]]
+			trace(st.Name, origin[start], text[start:aOff[h.aEnd]], added)
+			for i := 0; i < len(added); i++ {
+				next = append(next, origin[start])
+			}
+			prev = h.aEnd
+		}
+		origin = append(next, origin[aOff[prev]:]...)
+		return fixed
+	})
+}
+
+// runeOffsets returns the byte offset of every rune of s, as ranging over s
+// sees them (an invalid byte is a rune of its own), followed by len(s).
+func runeOffsets(s string) []int {
+	offsets := make([]int, 0, len(s)+1)
+	for i := range s {
+		offsets = append(offsets, i)
+	}
+	return append(offsets, len(s))
+}
+
+// wordHunks is diffHunks widened to whitespace-delimited word boundaries,
+// merging hunks that end up touching the same word.
+func wordHunks(a, b []rune) []diffHunk {
+	hunks := diffHunks(a, b)
+	var out []diffHunk
+	for k := 0; k < len(hunks); k++ {
+		h := hunks[k]
+		for h.aStart > 0 && !unicode.IsSpace(a[h.aStart-1]) && (len(out) == 0 || h.aStart > out[len(out)-1].aEnd) {
+			h.aStart--
+			h.bStart--
+		}
+		for {
+			for h.aEnd < len(a) && !unicode.IsSpace(a[h.aEnd]) && (k+1 == len(hunks) || h.aEnd < hunks[k+1].aStart) {
+				h.aEnd++
+				h.bEnd++
+			}
+			if k+1 < len(hunks) && h.aEnd == hunks[k+1].aStart {
+				k++
+				h.aEnd, h.bEnd = hunks[k].aEnd, hunks[k].bEnd
+				continue
+			}
+			break
+		}
+		out = append(out, h)
+	}
+	return out
+}
+
+var (
+	sedPatternEscaper     = strings.NewReplacer(`\`, `\\`, "/", `\/`, ".", `\.`, "*", `\*`, "[", `\[`, "]", `\]`, "^", `\^`, "$", `\$`, "\n", `\n`)
+	sedReplacementEscaper = strings.NewReplacer(`\`, `\\`, "/", `\/`, "&", `\&`, "\n", `\n`)
+)
+
+// sedPattern escapes s for use as a literal sed regular expression.
+func sedPattern(s string) string { return sedPatternEscaper.Replace(s) }
+
+// sedReplacement escapes s for use as a literal sed replacement.
+func sedReplacement(s string) string { return sedReplacementEscaper.Replace(s) }
+
+// diffHunk is a changed region: a[aStart:aEnd] was replaced by b[bStart:bEnd].
+// Either side may be empty for pure deletions or insertions.
+type diffHunk struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+// maxDiffCells bounds the LCS table size. Inputs whose differing middle
+// section exceeds it are reported as a single replaced hunk.
+const maxDiffCells = 1 << 22
+
+// diffHunks returns the changed regions between a and b, in order, based on a
+// longest-common-subsequence alignment. Common prefixes and suffixes are
+// trimmed first so that long, mostly-equal inputs stay cheap.
+func diffHunks[T comparable](a, b []T) []diffHunk {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+	if len(ma) == 0 && len(mb) == 0 {
+		return nil
+	}
+	if len(ma) == 0 || len(mb) == 0 || (len(ma)+1)*(len(mb)+1) > maxDiffCells {
+		return []diffHunk{{pre, pre + len(ma), pre, pre + len(mb)}}
+	}
+
+	// lcs[i][j] is the LCS length of ma[i:] and mb[j:].
+	w := len(mb) + 1
+	lcs := make([]int, (len(ma)+1)*w)
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i*w+j] = lcs[(i+1)*w+j+1] + 1
+			} else {
+				lcs[i*w+j] = max(lcs[(i+1)*w+j], lcs[i*w+j+1])
+			}
+		}
+	}
+
+	var hunks []diffHunk
+	open := false
+	var cur diffHunk
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		if i < len(ma) && j < len(mb) && ma[i] == mb[j] {
+			if open {
+				hunks = append(hunks, cur)
+				open = false
+			}
+			i++
+			j++
+			continue
+		}
+		if !open {
+			cur = diffHunk{pre + i, pre + i, pre + j, pre + j}
+			open = true
+		}
+		if j >= len(mb) || (i < len(ma) && lcs[(i+1)*w+j] >= lcs[i*w+j+1]) {
+			i++
+			cur.aEnd = pre + i
+		} else {
+			j++
+			cur.bEnd = pre + j
+		}
+	}
+	if open {
+		hunks = append(hunks, cur)
+	}
+	return hunks
+}
@@ -1,22 +1,134 @@
 package goftfy
 
 import (
+	"bytes"
+	"context"
+	"slices"
 	"strings"
+	"unicode"
 	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 // fixEncoding is the core mojibake fixer.
 // Mojibake happens when UTF-8 bytes are decoded as Latin-1 (ISO-8859-1)
 // and then re-encoded. We detect and reverse this.
-func fixEncoding(text string) string {
+//
+// Decisions are made per script segment (see SegmentByScript), so a
+// mojibake signal in one run of text never causes a differently-scripted run
+// elsewhere in the string to be reinterpreted.
+func fixEncoding(text string, opts Options) string {
+	if fixed, ok := decodeAs(text, opts.SourceEncoding); ok {
+		return fixed
+	}
+	text = collapseNBSPRuns(text)
+	switch strings.ToLower(opts.SourceCharset) {
+	case "utf-16le":
+		return fixUTF16Mojibake(text, false)
+	case "utf-16be":
+		return fixUTF16Mojibake(text, true)
+	}
+	if utf8.ValidString(text) && !looksLikeMojibake(text) {
+		if fixed, ok := detectUTF16Mojibake(text); ok {
+			return fixed
+		}
+		return text
+	}
+	segs := SegmentByScript(text)
+	if len(segs) == 1 {
+		return fixEncodingRun(text, opts)
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, seg := range segs {
+		b.WriteString(fixEncodingRun(seg.Text, opts))
+	}
+	return b.String()
+}
+
+// decodeAs implements Options.SourceEncoding: it turns each rune of text
+// back into the Latin-1 or Windows-1252 byte it was misread from and
+// decodes the bytes with the named encoding. Text holding a rune neither
+// charset produces, or that would gain U+FFFD replacement characters, was
+// not misread that way and is returned unchanged. It reports false if name
+// is empty or unknown.
+func decodeAs(text, name string) (string, bool) {
+	if name == "" {
+		return text, false
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return text, false
+	}
+	raw := make([]byte, 0, len(text))
+	for _, r := range text {
+		b, ok := mojibakeByte(r)
+		if !ok {
+			return text, true
+		}
+		raw = append(raw, b)
+	}
+	decoded, err := enc.NewDecoder().Bytes(raw)
+	if err != nil || bytes.Count(decoded, []byte("\uFFFD")) > strings.Count(text, "\uFFFD") {
+		return text, true
+	}
+	return string(decoded), true
+}
+
+// collapseNBSPRuns repairs runs such as "Â Â " left where a no-break space
+// went through several rounds of corruption, each adding an "Â" and a space.
+// A run of two or more "Â"+space pairs collapses to its final space, keeping
+// it a no-break space if it was one. A single "Â\u00A0" is ordinary NBSP
+// mojibake and is left for the decoder.
+func collapseNBSPRuns(text string) string {
+	if !strings.Contains(text, "Â") {
+		return text
+	}
+	rs := []rune(text)
+	out := rs[:0:0]
+	for i := 0; i < len(rs); {
+		j := i
+		for j+1 < len(rs) && rs[j] == 'Â' && (rs[j+1] == ' ' || rs[j+1] == '\u00A0') {
+			j += 2
+		}
+		switch {
+		case j-i >= 4:
+			out = append(out, rs[j-1])
+			i = j
+		case j > i:
+			out = append(out, rs[i:j]...)
+			i = j
+		default:
+			out = append(out, rs[i])
+			i++
+		}
+	}
+	return string(out)
+}
+
+// fixEncodingRun repairs a single script segment.
+func fixEncodingRun(text string, opts Options) string {
 	if utf8.ValidString(text) && !looksLikeMojibake(text) {
 		return text
 	}
 	// Try to recover UTF-8 from Latin-1 mojibake
-	result := decodeMojibake(text)
+	result := decodeMojibake(text, opts)
 	if result != text && utf8.ValidString(result) {
 		return result
 	}
+	// Latin-1 decoding cannot undo Windows-1252 forms such as "â‚¬". Replace
+	// the known ones, then retry on whatever mojibake is left; as above, a
+	// result that still looks broken is worse than leaving the text alone.
+	if patched := QuickFix(text); patched != text {
+		if result := decodeMojibake(patched, opts); result != patched && utf8.ValidString(result) {
+			return result
+		}
+		if !looksLikeMojibake(patched) {
+			return patched
+		}
+	}
 	return text
 }
 
@@ -32,7 +144,7 @@ func looksLikeMojibake(text string) bool {
 		// (often shows up as "Ã©", "Ã±", "Ã£", etc.).
 		if r == 'Ã' && i+1 < len(rs) {
 			next := rs[i+1]
-			if next >= 0x80 && next <= 0xBF {
+			if isContinuationChar(next) {
 				return true
 			}
 			switch next {
@@ -41,39 +153,362 @@ func looksLikeMojibake(text string) bool {
 			}
 		}
 
-		// Common Windows-1252 mojibake sequences often start with â / Â.
-		if r == 'â' || r == 'Â' {
+		// Latin Extended-A (U+0100–U+017F) has UTF-8 lead bytes C4 and C5,
+		// shown as "Ä" and "Å"; their continuation byte is often a C1
+		// control (Latin-1) or a Windows-1252 symbol such as "™". Cyrillic
+		// (D0, D1) and Greek (CE, CF) read the same way show as "Ð", "Ñ",
+		// "Î" and "Ï".
+		switch r {
+		case 'Ä', 'Å', 'Ð', 'Ñ', 'Î', 'Ï':
+			if i+1 < len(rs) && isContinuationChar(rs[i+1]) {
+				return true
+			}
+		}
+
+		if i+1 < len(rs) && foreignMojibakeAt(rs[i], rs[i+1]) != "" {
+			return true
+		}
+
+		// Common Windows-1252 mojibake sequences often start with â / Â
+		// ("â€™", "Â©"), but both are ordinary letters in French, Portuguese
+		// and Vietnamese ("âme"), so a continuation byte has to follow.
+		if (r == 'â' || r == 'Â') && i+1 < len(rs) && isContinuationChar(rs[i+1]) {
 			return true
 		}
 	}
 	return false
 }
 
-// decodeMojibake reverses Latin-1 misinterpretation of UTF-8.
-// This reinterprets each rune as its Latin-1 byte value and re-decodes as UTF-8.
-func decodeMojibake(text string) string {
-	// Convert string to raw Latin-1 bytes.
+// foreignMojibakeAt returns the charset that UTF-8 text was misread as if
+// the pair r, next is the lead and first continuation byte of a Cyrillic or
+// Greek character in that charset, or "" if it is not. Windows-1251 shows
+// the Cyrillic lead bytes D0–D3 as "Р", "С", "Т" and "У"; ISO-8859-7 and
+// Windows-1253 show the Greek lead bytes CE and CF as "Ξ" and "Ο".
+func foreignMojibakeAt(r, next rune) string {
+	isCont := func(toByte func(rune) (byte, bool)) bool {
+		b, ok := toByte(next)
+		return ok && b >= 0x80 && b <= 0xBF
+	}
+	switch r {
+	case 'Р', 'С', 'Т', 'У':
+		if isCont(cp1251Byte) {
+			return "windows-1251"
+		}
+	case 'Ξ', 'Ο':
+		if isCont(iso8859_7Byte) {
+			return "iso-8859-7"
+		}
+		if isCont(cp1253Byte) {
+			return "windows-1253"
+		}
+	}
+	return ""
+}
+
+// isContinuationChar reports whether r is a UTF-8 continuation byte
+// (0x80–0xBF) as shown by Latin-1 or Windows-1252.
+func isContinuationChar(r rune) bool {
+	b, ok := mojibakeByte(r)
+	return ok && b >= 0x80 && b <= 0xBF
+}
+
+// defaultDecodePasses is how many layers of mojibake decodeMojibake peels
+// off when Options.MaxDecodePasses is zero. Text is rarely mangled more than
+// three times, and every layer must shrink the non-ASCII count, so the cap
+// only guards against pathological input.
+const defaultDecodePasses = 4
+
+// decodeMojibake reverses UTF-8 misread as Latin-1 or Windows-1252: every
+// rune is turned back into the byte it was decoded from and the bytes are
+// re-decoded as UTF-8. Each round-trip in opts.CharsetPreference is tried
+// and the candidate with the fewest remaining non-ASCII runes wins, the
+// earlier charset on a tie. Latin-1 leaves genuine typographic characters
+// such as "’" alone, which keeps mixed text decodable; Windows-1252 maps
+// them back to the bytes 0x80–0x9F, which is needed for "â€™" or "Ã€".
+//
+// Text that went through the misreading more than once ("ÃƒÂ©" for "é")
+// is peeled one layer at a time, up to opts.MaxDecodePasses layers, until
+// no mojibake sequence remains. If decoding stops improving first, the
+// input was only partially re-encoded (for example "Ã\u0083" leftovers);
+// half-decoding it would make things harder to repair later, so the text
+// is returned unchanged instead.
+func decodeMojibake(text string, opts Options) string {
+	passes := opts.MaxDecodePasses
+	if passes <= 0 {
+		passes = defaultDecodePasses
+	}
+	mappers := charsetByteMappers(opts.CharsetPreference)
+	cur := text
+	for pass := 0; pass < passes; pass++ {
+		best, bestScore := "", -1
+		for _, toByte := range mappers {
+			candidate, ok := reinterpretBytes(cur, toByte)
+			if !ok {
+				continue
+			}
+			if score := countNonASCII(candidate); bestScore < 0 || score < bestScore {
+				best, bestScore = candidate, score
+			}
+		}
+		if bestScore < 0 {
+			break
+		}
+		if !hasMojibakeSequence(best) {
+			return best
+		}
+		cur = best
+	}
+	return text
+}
+
+// defaultCharsetPreference is used when Options.CharsetPreference names no
+// supported charset. The Cyrillic and Greek charsets come last: they only
+// win when the Latin ones cannot decode the text at all.
+var defaultCharsetPreference = []string{
+	"iso-8859-1", "windows-1252", "windows-1251", "iso-8859-7", "windows-1253",
+}
+
+// charsetByteMappers returns the rune-to-byte mappings for the charsets in
+// prefs that decodeMojibake supports, in order. Other names are ignored; if
+// none are supported the default preference applies.
+func charsetByteMappers(prefs []string) []func(rune) (byte, bool) {
+	var mappers []func(rune) (byte, bool)
+	for _, name := range prefs {
+		switch strings.ToLower(name) {
+		case "iso-8859-1", "latin-1", "latin1":
+			mappers = append(mappers, latin1Byte)
+		case "windows-1252", "cp1252":
+			mappers = append(mappers, cp1252Byte)
+		case "windows-1251", "cp1251":
+			mappers = append(mappers, cp1251Byte)
+		case "iso-8859-7", "greek":
+			mappers = append(mappers, iso8859_7Byte)
+		case "windows-1253", "cp1253":
+			mappers = append(mappers, cp1253Byte)
+		}
+	}
+	if len(mappers) == 0 {
+		return charsetByteMappers(defaultCharsetPreference)
+	}
+	return mappers
+}
+
+func latin1Byte(r rune) (byte, bool) {
+	return byte(r), r < 0x100
+}
+
+// cp1252Byte maps r to its Windows-1252 byte. C1 controls only map for the
+// five bytes Windows-1252 leaves undefined, where lenient decoders emit
+// them; a Windows-1252 decoder never produces the others.
+func cp1252Byte(r rune) (byte, bool) {
+	switch {
+	case r < 0x80 || (r >= 0xA0 && r < 0x100):
+		return byte(r), true
+	case r == 0x81 || r == 0x8D || r == 0x8F || r == 0x90 || r == 0x9D:
+		return byte(r), true
+	}
+	return charmap.Windows1252.EncodeRune(r)
+}
+
+// Rune-to-byte mappings for the Cyrillic and Greek charsets.
+var (
+	cp1251Byte    = legacyByte(charmap.Windows1251)
+	iso8859_7Byte = legacyByte(charmap.ISO8859_7)
+	cp1253Byte    = legacyByte(charmap.Windows1253)
+)
+
+// legacyByte returns the rune-to-byte mapping of cm. C1 controls map to the
+// byte of the same value, as lenient decoders emit them for bytes a charset
+// leaves undefined, such as most of 0x80–0x9F in ISO-8859-7.
+func legacyByte(cm *charmap.Charmap) func(rune) (byte, bool) {
+	return func(r rune) (byte, bool) {
+		if r < 0xA0 {
+			return byte(r), true
+		}
+		return cm.EncodeRune(r)
+	}
+}
+
+// reinterpretBytes maps each rune of text to a byte with toByte, keeping the
+// UTF-8 encoding of runes it cannot map, and decodes the result as UTF-8.
+// It reports false unless the result is valid UTF-8 with fewer non-ASCII
+// runes than text.
+func reinterpretBytes(text string, toByte func(rune) (byte, bool)) (string, bool) {
 	// Pre-size to byte length as a reasonable upper bound for most mojibake strings.
 	rawBytes := make([]byte, 0, len(text))
 	for _, r := range text {
-		if r < 0x100 {
-			rawBytes = append(rawBytes, byte(r))
+		if b, ok := toByte(r); ok {
+			rawBytes = append(rawBytes, b)
 		} else {
-			// Not a Latin-1 character; append its UTF-8 encoding.
 			rawBytes = utf8.AppendRune(rawBytes, r)
 		}
 	}
+	if !utf8.Valid(rawBytes) {
+		return "", false
+	}
+	candidate := string(rawBytes)
+	if countNonASCII(candidate) >= countNonASCII(text) {
+		return "", false
+	}
+	return candidate, true
+}
+
+// hasMojibakeSequence reports whether text contains a sequence ScanPatterns
+// would report.
+func hasMojibakeSequence(text string) bool {
+	rs := []rune(text)
+	for i := range rs {
+		if n, _ := mojibakeAt(rs, i); n > 0 {
+			return true
+		}
+	}
+	return false
+}
 
-	if utf8.Valid(rawBytes) {
-		candidate := string(rawBytes)
-		// Make sure we actually improved things
-		if countNonASCII(candidate) < countNonASCII(text) {
-			return candidate
+// Mojibakify produces the corruption Fix repairs: the UTF-8 bytes of text
+// are decoded as charset, which is any name known to the WHATWG encoding
+// standard. "latin-1" (and "iso-8859-1") maps every byte to the code point of
+// the same value. "windows-1252" (and "cp1252") maps the five bytes that
+// charset leaves undefined to the C1 controls of the same value, as many
+// real decoders do, so that no information is lost. Other charsets may be
+// lossy. An unknown charset returns text unchanged. It is meant for tests
+// and for generating training data.
+func Mojibakify(text, charset string) string {
+	var decodeByte func(byte) rune
+	switch strings.ToLower(charset) {
+	case "latin-1", "latin1", "iso-8859-1", "iso8859-1":
+		decodeByte = func(b byte) rune { return rune(b) }
+	case "windows-1252", "cp1252":
+		decodeByte = func(b byte) rune {
+			if r := charmap.Windows1252.DecodeByte(b); r != utf8.RuneError {
+				return r
+			}
+			return rune(b)
+		}
+	default:
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return text
+		}
+		out, err := enc.NewDecoder().String(text)
+		if err != nil {
+			return text
 		}
+		return out
+	}
+	var b strings.Builder
+	b.Grow(2 * len(text))
+	for i := 0; i < len(text); i++ {
+		b.WriteRune(decodeByte(text[i]))
+	}
+	return b.String()
+}
+
+// fixUTF16Mojibake reverses text whose UTF-8 bytes were decoded as UTF-16
+// with the given byte order, leaving it unchanged if that is impossible.
+func fixUTF16Mojibake(text string, bigEndian bool) string {
+	if fixed, ok := recoverUTF16(text, bigEndian); ok {
+		return fixed
 	}
 	return text
 }
 
+// recoverUTF16 re-encodes text as UTF-16 in the given byte order and decodes
+// the resulting bytes as UTF-8. ASCII runes pass through as single bytes:
+// the mojibake never produces them from a byte pair, but a trailing odd byte
+// or separators added later may survive as ASCII.
+func recoverUTF16(text string, bigEndian bool) (string, bool) {
+	buf := make([]byte, 0, len(text))
+	for _, r := range text {
+		switch {
+		case r <= unicode.MaxASCII:
+			buf = append(buf, byte(r))
+		case r > 0xFFFF || (r >= 0xD800 && r <= 0xDFFF):
+			return "", false
+		case bigEndian:
+			buf = append(buf, byte(r>>8), byte(r))
+		default:
+			buf = append(buf, byte(r), byte(r>>8))
+		}
+	}
+	if !utf8.Valid(buf) {
+		return "", false
+	}
+	return string(buf), true
+}
+
+// detectUTF16Mojibake recognizes ASCII text that was decoded as UTF-16 (the
+// "Bush hid the facts" problem), which turns every pair of letters into one
+// CJK-looking character. It tries both byte orders and accepts a candidate
+// only when it is entirely printable ASCII containing a space, which real
+// CJK text essentially never re-encodes to. Pure ASCII qualifies in both
+// byte orders (one is the other with each byte pair swapped), so ties are
+// broken by how many common bigrams each candidate contains, and then in
+// favour of little-endian, which is what Windows software produces.
+func detectUTF16Mojibake(text string) (string, bool) {
+	wide := 0
+	for i, r := range text {
+		if r <= unicode.MaxASCII {
+			// Only a single trailing odd byte may be left as ASCII.
+			if i != len(text)-1 {
+				return "", false
+			}
+			continue
+		}
+		wide++
+	}
+	if wide < 4 {
+		return "", false
+	}
+
+	best, bestScore := "", -1
+	for _, bigEndian := range []bool{false, true} {
+		candidate, ok := recoverUTF16(text, bigEndian)
+		if !ok || !isPlainASCIIProse(candidate) {
+			continue
+		}
+		if score := bigramScore(candidate); score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best, bestScore >= 0
+}
+
+// isPlainASCIIProse reports whether s is printable ASCII with at least one
+// space between words.
+func isPlainASCIIProse(s string) bool {
+	hasSpace := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ':
+			hasSpace = true
+		case c == '\t' || c == '\n' || c == '\r' || (c > ' ' && c < 0x7F):
+		default:
+			return false
+		}
+	}
+	return hasSpace
+}
+
+// commonBigrams are frequent letter pairs in English and other Latin-script
+// languages, plus punctuation followed by a space.
+var commonBigrams = []string{
+	"th", "he", "in", "er", "an", "re", "nd", "on", "en", "at",
+	"ou", "ed", "ha", "to", "or", "it", "is", "hi", "es", "ng",
+	", ", ". ",
+}
+
+// bigramScore counts occurrences of commonBigrams in s, ignoring case.
+func bigramScore(s string) int {
+	s = strings.ToLower(s)
+	score := 0
+	for _, bg := range commonBigrams {
+		score += strings.Count(s, bg)
+	}
+	return score
+}
+
 func countNonASCII(s string) int {
 	count := 0
 	for _, r := range s {
@@ -84,8 +519,99 @@ func countNonASCII(s string) int {
 	return count
 }
 
+// PatternTable is a list of literal replacements for QuickFixWith. The text
+// is scanned once and, at each position, the longest matching Broken string
+// is replaced; replacements are not rescanned. If two entries have the same
+// Broken string, the earlier one wins.
+type PatternTable []struct{ Broken, Fixed string }
+
+// Replacer compiles t into a single-pass matcher. strings.Replacer looks
+// matches up in a trie and, at each position, takes the first matching pair
+// in argument order, so the pairs are sorted longest first; duplicates after
+// the first are dropped. Build it once to reuse a table across many calls.
+func (t PatternTable) Replacer() *strings.Replacer {
+	table := slices.Clone(t)
+	slices.SortStableFunc(table, func(a, b struct{ Broken, Fixed string }) int {
+		return len(b.Broken) - len(a.Broken)
+	})
+	pairs := make([]string, 0, 2*len(table))
+	seen := make(map[string]bool, len(table))
+	for _, p := range table {
+		if p.Broken == "" || seen[p.Broken] {
+			continue
+		}
+		seen[p.Broken] = true
+		pairs = append(pairs, p.Broken, p.Fixed)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
 // commonMojibakePatternsOrdered is the deterministic replacement order for QuickFix.
-var commonMojibakePatternsOrdered = []struct{ broken, fixed string }{
+var commonMojibakePatternsOrdered = PatternTable{
+	// Currency symbols, tried first: they are high-value and their
+	// Windows-1252 forms ("â‚¬") cannot be undone by Latin-1 decoding.
+	{"â‚¬", "€"}, // euro sign
+	{"Â£", "£"},  // pound sign
+	{"Â¥", "¥"},  // yen sign
+	{"Â¢", "¢"},  // cent sign
+	{"Â¤", "¤"},  // currency sign
+	{"â‚¹", "₹"}, // indian rupee sign
+	{"â‚½", "₽"}, // ruble sign
+	{"â‚©", "₩"}, // won sign
+	{"â‚ª", "₪"}, // new shekel sign
+	{"â‚«", "₫"}, // dong sign
+	{"â‚º", "₺"}, // turkish lira sign
+	{"â‚±", "₱"}, // peso sign
+	{"â‚´", "₴"}, // hryvnia sign
+	{"â‚¦", "₦"}, // naira sign
+	{"â‚¿", "₿"}, // bitcoin sign
+
+	// Latin-1 supplement symbols common in scientific and technical data.
+	{"Â°", "°"}, // degree sign
+	{"Âµ", "µ"}, // micro sign
+	{"Â±", "±"}, // plus-minus sign
+	{"Â¹", "¹"}, // superscript one
+	{"Â²", "²"}, // superscript two
+	{"Â³", "³"}, // superscript three
+	{"Â¼", "¼"}, // vulgar fraction one quarter
+	{"Â½", "½"}, // vulgar fraction one half
+	{"Â¾", "¾"}, // vulgar fraction three quarters
+	{"Ã—", "×"}, // multiplication sign
+	{"Ã·", "÷"}, // division sign
+	{"Â§", "§"}, // section sign
+	{"Â¶", "¶"}, // pilcrow sign
+	{"Âª", "ª"}, // feminine ordinal indicator
+	{"Âº", "º"}, // masculine ordinal indicator
+	{"Â¬", "¬"}, // not sign
+
+	// Latin Extended-A letters (Polish, Czech, Turkish, ...) whose second
+	// UTF-8 byte is shown as a Windows-1252 character.
+	{"Ä„", "Ą"}, // latin capital letter a with ogonek
+	{"Ä…", "ą"}, // latin small letter a with ogonek
+	{"Ä†", "Ć"}, // latin capital letter c with acute
+	{"Ä‡", "ć"}, // latin small letter c with acute
+	{"ÄŒ", "Č"}, // latin capital letter c with caron
+	{"ÄŽ", "Ď"}, // latin capital letter d with caron
+	{"Ä‘", "đ"}, // latin small letter d with stroke
+	{"Ä˜", "Ę"}, // latin capital letter e with ogonek
+	{"Ä™", "ę"}, // latin small letter e with ogonek
+	{"Äš", "Ě"}, // latin capital letter e with caron
+	{"Ä›", "ě"}, // latin small letter e with caron
+	{"Äž", "Ğ"}, // latin capital letter g with breve
+	{"ÄŸ", "ğ"}, // latin small letter g with breve
+	{"Å‚", "ł"}, // latin small letter l with stroke
+	{"Åƒ", "Ń"}, // latin capital letter n with acute
+	{"Å„", "ń"}, // latin small letter n with acute
+	{"Å‡", "Ň"}, // latin capital letter n with caron
+	{"Åˆ", "ň"}, // latin small letter n with caron
+	{"Å‘", "ő"}, // latin small letter o with double acute
+	{"Å˜", "Ř"}, // latin capital letter r with caron
+	{"Å™", "ř"}, // latin small letter r with caron
+	{"Åš", "Ś"}, // latin capital letter s with acute
+	{"Å›", "ś"}, // latin small letter s with acute
+	{"Åž", "Ş"}, // latin capital letter s with cedilla
+	{"ÅŸ", "ş"}, // latin small letter s with cedilla
+
 	{"SÃ£o", "São"},
 	{"cafÃ©", "café"},
 	{"clichÃ©", "cliché"},
@@ -111,7 +637,7 @@ var commonMojibakePatternsOrdered = []struct{ broken, fixed string }{
 var commonMojibakePatternsMap = func() map[string]string {
 	m := make(map[string]string, len(commonMojibakePatternsOrdered))
 	for _, p := range commonMojibakePatternsOrdered {
-		m[p.broken] = p.fixed
+		m[p.Broken] = p.Fixed
 	}
 	return m
 }()
@@ -128,11 +654,66 @@ func CommonMojibakePatterns() map[string]string {
 	return out
 }
 
+// quickFixReplacer is the compiled form of the built-in QuickFix patterns.
+var quickFixReplacer = commonMojibakePatternsOrdered.Replacer()
+
 // QuickFix applies a fast dictionary lookup for the most common mojibake patterns.
-// Faster than the full Fix() for known patterns but less comprehensive.
+// Faster than the full Fix() for known patterns but less comprehensive. The
+// text is scanned once, longest match first.
 func QuickFix(text string) string {
+	return quickFixReplacer.Replace(text)
+}
+
+// QuickFixWith is QuickFix with a caller-supplied pattern table, such as a
+// domain dictionary of garbled product names. Use NewPatternTable to extend
+// the built-in patterns rather than replace them. The table is compiled on
+// every call; to reuse one, compile it once with PatternTable.Replacer.
+func QuickFixWith(text string, table PatternTable) string {
+	return table.Replacer().Replace(text)
+}
+
+// NewPatternTable returns custom followed by the built-in QuickFix patterns.
+// Custom entries take precedence: they are applied first, and a built-in
+// entry with the same Broken string is dropped. custom is not modified.
+func NewPatternTable(custom PatternTable) PatternTable {
+	table := make(PatternTable, 0, len(custom)+len(commonMojibakePatternsOrdered))
+	table = append(table, custom...)
+	seen := make(map[string]bool, len(custom))
+	for _, p := range custom {
+		seen[p.Broken] = true
+	}
 	for _, p := range commonMojibakePatternsOrdered {
-		text = strings.ReplaceAll(text, p.broken, p.fixed)
+		if !seen[p.Broken] {
+			table = append(table, p)
+		}
 	}
-	return text
-}
\ No newline at end of file
+	return table
+}
+
+// quickFixChunk is roughly how much text QuickFixContext fixes between
+// checks of its context.
+const quickFixChunk = 64 << 10
+
+// QuickFixContext is QuickFix with cancellation: ctx is checked between
+// chunks of the text so that huge inputs cannot run past a deadline. Chunks
+// end at a space or newline, which no built-in pattern contains.
+func QuickFixContext(ctx context.Context, text string) (string, error) {
+	var b strings.Builder
+	b.Grow(len(text))
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if text == "" {
+			return b.String(), nil
+		}
+		end := len(text)
+		if end > quickFixChunk {
+			if i := strings.IndexAny(text[quickFixChunk:], " \n"); i >= 0 {
+				end = quickFixChunk + i + 1
+			}
+		}
+		quickFixReplacer.WriteString(&b, text[:end])
+		text = text[end:]
+	}
+}
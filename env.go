@@ -0,0 +1,61 @@
+package goftfy
+
+import "strings"
+
+// FixEnv fixes the values of a .env-style file, where each line holds
+// KEY=value. Only the value after the first '=' is fixed: keys (including an
+// "export " prefix), blank lines and comment lines are left as they are. A
+// value wrapped in single or double quotes is fixed between the quotes, which
+// are kept, and anything after the closing quote such as a trailing comment
+// is preserved; for unquoted values a trailing " #" comment is preserved too.
+func FixEnv(text string, opts Options) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = fixEnvLine(line, opts)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func fixEnvLine(line string, opts Options) string {
+	body, cr := strings.CutSuffix(line, "\r")
+	trimmed := strings.TrimSpace(body)
+	eq := strings.IndexByte(body, '=')
+	if trimmed == "" || trimmed[0] == '#' || eq < 0 {
+		return line
+	}
+	key, value := body[:eq+1], body[eq+1:]
+
+	prefix, inner, suffix := "", value, ""
+	if value != "" && (value[0] == '"' || value[0] == '\'') {
+		end := closingQuote(value)
+		if end < 0 {
+			return line
+		}
+		prefix, inner, suffix = value[:1], value[1:end], value[end:]
+	} else if c := strings.Index(value, " #"); c >= 0 {
+		inner, suffix = value[:c], value[c:]
+	}
+
+	out := key + prefix + FixWithOptions(inner, opts.fragment()) + suffix
+	if cr {
+		out += "\r"
+	}
+	return out
+}
+
+// closingQuote returns the index in s of the quote that closes the one at
+// s[0], skipping backslash-escaped quotes inside double quotes, or -1.
+func closingQuote(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i
+		}
+	}
+	return -1
+}
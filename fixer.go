@@ -0,0 +1,97 @@
+package goftfy
+
+import "sync"
+
+// Fixer applies a fixed set of options. Building the stage list for a set of
+// options compiles replacers and allocates closures; a Fixer does that once,
+// in NewFixer, instead of on every call, which matters when fixing millions
+// of short strings.
+//
+// A Fixer is safe for concurrent use by multiple goroutines.
+type Fixer struct {
+	opts   Options
+	stages []Stage
+}
+
+// NewFixer returns a Fixer that fixes text with opts.
+func NewFixer(opts Options) *Fixer {
+	return &Fixer{opts: opts, stages: opts.Pipeline()}
+}
+
+// Fix returns text fixed with the Fixer's options, the same result as
+// FixWithOptions.
+func (f *Fixer) Fix(text string) string {
+	if statsEnabled.Load() {
+		return fixCounted(text, f.opts)
+	}
+	return fixRounds(text, f.opts, f.stages)
+}
+
+// FixChan fixes the strings received from in on workers goroutines and
+// sends the results, in input order, on the returned channel, which is
+// closed once in is closed and drained. A workers value below 1 means one.
+// At most 2*workers strings are in flight at a time, so one slow string holds
+// up later ones rather than letting their results pile up without bound.
+// The caller must read the output channel to the end; abandoning it leaves
+// the goroutines blocked.
+func FixChan(in <-chan string, opts Options, workers int) <-chan string {
+	workers = max(workers, 1)
+	f := NewFixer(opts)
+	type item struct {
+		index int
+		text  string
+	}
+	jobs := make(chan item, workers)
+	results := make(chan item, workers)
+	out := make(chan string)
+	// inFlight holds a token for every string taken from in whose result
+	// has not been received from out yet.
+	inFlight := make(chan struct{}, 2*workers)
+
+	go func() {
+		i := 0
+		for text := range in {
+			inFlight <- struct{}{}
+			jobs <- item{i, text}
+			i++
+		}
+		close(jobs)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for it := range jobs {
+				results <- item{it.index, f.Fix(it.text)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results arrive in whatever order the workers finish; hold each one
+	// back until everything before it has been sent.
+	go func() {
+		defer close(out)
+		pending := make(map[int]string)
+		next := 0
+		for it := range results {
+			pending[it.index] = it.text
+			for {
+				text, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				out <- text
+				<-inFlight
+				next++
+			}
+		}
+	}()
+	return out
+}
@@ -1,6 +1,30 @@
 package goftfy
 
-import "testing"
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/unicode/norm"
+)
 
 func TestFixMojibake(t *testing.T) {
 	tests := []struct {
@@ -53,6 +77,107 @@ func TestFixControlChars(t *testing.T) {
 	}
 }
 
+func TestTrailingSpaceAndFinalNewline(t *testing.T) {
+	tests := []struct {
+		trim, final, tabs bool
+		input, expected   string
+	}{
+		{true, false, false, "a \t\nb\t\t\nc  ", "a\nb\nc"},
+		{true, false, false, "a \r\nb\u00A0\r\n", "a\nb\n"},
+		{true, false, true, "id\tname\t \n1\t\t\n", "id\tname\t\n1\t\t\n"},
+		{false, true, false, "no newline", "no newline\n"},
+		{false, true, false, "many\n\n\r\n", "many\n"},
+		{false, true, false, "", ""},
+		{true, true, false, "a  \n  \n\t\n", "a\n"},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.TrimTrailingSpace, opts.EnsureFinalNewline, opts.TabIsDelimiter = tt.trim, tt.final, tt.tabs
+		if got := FixWithOptions(tt.input, opts); got != tt.expected {
+			t.Errorf("FixWithOptions(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+
+	// Both work without FixLineBreaks, keeping the text's own terminators.
+	opts := Options{TrimTrailingSpace: true, EnsureFinalNewline: true, LineEndingStyle: "crlf"}
+	input, expected := "a \r\nb\t", "a\r\nb\r\n"
+	if got := FixWithOptions(input, opts); got != expected {
+		t.Errorf("FixWithOptions(%q) without FixLineBreaks = %q, want %q", input, got, expected)
+	}
+}
+
+func TestFinalNewlineWholeDocumentOnly(t *testing.T) {
+	opts := DefaultOptions()
+	opts.EnsureFinalNewline = true
+
+	tests := []struct {
+		pieces []string
+		want   string
+	}{
+		{[]string{"hello world foo", " bar", " baz"}, "hello world foo bar baz\n"},
+		{[]string{"caf", "Ã©\n\n", "\n"}, "café\n"},
+		{[]string{"done\n"}, "done\n"},
+		{[]string{"trailing \x07"}, "trailing \n"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		sf := NewStreamFixer(opts)
+		var out []byte
+		for _, p := range tt.pieces {
+			out = append(out, sf.Push([]byte(p))...)
+		}
+		out = append(out, sf.Flush()...)
+		if string(out) != tt.want {
+			t.Errorf("StreamFixer(%q) = %q, want %q", tt.pieces, out, tt.want)
+		}
+
+		input := strings.Join(tt.pieces, "")
+		var got bytes.Buffer
+		if _, err := io.Copy(&got, FixReader(iotest.OneByteReader(strings.NewReader(input)), opts)); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != tt.want {
+			t.Errorf("FixReader(%q) = %q, want %q", input, got.String(), tt.want)
+		}
+	}
+
+	if got := FixEnv("A=1\nB=\"x\"", opts); got != "A=1\nB=\"x\"" {
+		t.Errorf("FixEnv = %q, want values without added newlines", got)
+	}
+	if got, err := FixJSON([]byte(`{"a":"x"}`), opts); err != nil || string(got) != `{"a":"x"}` {
+		t.Errorf("FixJSON = %s, %v, want %s", got, err, `{"a":"x"}`)
+	}
+	if fixed, _ := FixPrefix("a b c", opts); fixed != "a b" {
+		t.Errorf("FixPrefix = %q, want %q", fixed, "a b")
+	}
+	sc := bufio.NewScanner(strings.NewReader("a b"))
+	sc.Split(FixWordSplitFunc(opts))
+	for sc.Scan() {
+		if strings.Contains(sc.Text(), "\n") {
+			t.Errorf("FixWordSplitFunc token = %q, want no newline", sc.Text())
+		}
+	}
+}
+
+func TestControlCharReplacement(t *testing.T) {
+	tests := []struct {
+		replacement string
+		input       string
+		expected    string
+	}{
+		{"", "hello\x01world\x07!", "helloworld!"},
+		{" ", "hello\x01world\x07!", "hello world !"},
+		{"␊", "a\x0Bb\u0085c\td\n", "a␊b␊c\td\n"},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.ControlCharReplacement = tt.replacement
+		if got := FixWithOptions(tt.input, opts); got != tt.expected {
+			t.Errorf("FixWithOptions(%q) with replacement %q = %q, want %q", tt.input, tt.replacement, got, tt.expected)
+		}
+	}
+}
+
 func TestFixCurlyQuotes(t *testing.T) {
 	opts := DefaultOptions()
 	opts.FixCurlyQuotes = true
@@ -94,6 +219,25 @@ func TestFixMap(t *testing.T) {
 	}
 }
 
+func TestFixMapReport(t *testing.T) {
+	input := map[string]string{
+		"city": "SÃ£o Paulo",
+		"name": "Alice",
+		"note": "AT&amp;T",
+	}
+	got := FixMapReport(input)
+	want := map[string]Change{
+		"city": {Before: "SÃ£o Paulo", After: "São Paulo", Count: 1},
+		"note": {Before: "AT&amp;T", After: "AT&T", Count: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FixMapReport = %v, want %v", got, want)
+	}
+	if input["city"] != "SÃ£o Paulo" {
+		t.Errorf("FixMapReport modified its input: city = %q", input["city"])
+	}
+}
+
 func TestQuickFix(t *testing.T) {
 	got := QuickFix("SÃ£o Paulo")
 	if got != "São Paulo" {
@@ -108,23 +252,2493 @@ func TestExplain(t *testing.T) {
 	}
 }
 
-func TestHasSurrogates(t *testing.T) {
-	if HasSurrogates("clean text") {
-		t.Error("should not detect surrogates in clean text")
+func TestExplainMessage(t *testing.T) {
+	got := Explain("SÃ£o Paulo &amp; Rio", "São Paulo & Rio")
+	want := "Fixes applied: fixed mojibake encoding, decoded HTML entities."
+	if got != want {
+		t.Errorf("Explain = %q, want %q", got, want)
 	}
 }
 
-func TestHasReplacementChars(t *testing.T) {
-	if !HasReplacementChars("bad\uFFFDtext") {
-		t.Error("should detect replacement char")
+func TestExplainWithOptions(t *testing.T) {
+	opts := Options{FixEncoding: true, FixHTMLEntities: true, FixCurlyQuotes: true}
+	fixed, changes := ExplainWithOptions("cafÃ© &amp; cafÃ© \u201cbar\u201d", opts)
+	if want := "café & café \"bar\""; fixed != want {
+		t.Errorf("ExplainWithOptions fixed = %q, want %q", fixed, want)
+	}
+	want := []Change{
+		{Stage: "encoding", Before: "cafÃ©", After: "café", Count: 2},
+		{Stage: "html_entities", Before: "&amp;", After: "&", Count: 1},
+		{Stage: "curly_quotes", Before: "\u201cbar\u201d", After: "\"bar\"", Count: 1},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("ExplainWithOptions changes = %+v, want %+v", changes, want)
+	}
+
+	// Stages disabled by opts are not run, so they cannot be reported.
+	if _, changes := ExplainWithOptions("&amp;", Options{FixEncoding: true}); len(changes) != 0 {
+		t.Errorf("ExplainWithOptions(%q, FixEncoding only) = %+v, want no changes", "&amp;", changes)
 	}
 }
 
-func TestRemoveTerminalEscapes(t *testing.T) {
+func TestLineEndingStyle(t *testing.T) {
+	input := "one\r\ntwo\rthree\nfour\u2028five"
+	tests := []struct {
+		style string
+		want  string
+	}{
+		{"", "one\ntwo\nthree\nfour\nfive"},
+		{"lf", "one\ntwo\nthree\nfour\nfive"},
+		{"crlf", "one\r\ntwo\r\nthree\r\nfour\r\nfive"},
+		{"CRLF", "one\r\ntwo\r\nthree\r\nfour\r\nfive"},
+		{"cr", "one\rtwo\rthree\rfour\rfive"},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.LineEndingStyle = tt.style
+		got := FixWithOptions(input, opts)
+		if got != tt.want {
+			t.Errorf("FixWithOptions(%q, LineEndingStyle %q) = %q, want %q", input, tt.style, got, tt.want)
+		}
+		if again := FixWithOptions(got, opts); again != got {
+			t.Errorf("FixWithOptions(%q, LineEndingStyle %q) = %q, want it unchanged", got, tt.style, again)
+		}
+	}
+}
+
+func TestStripBOM(t *testing.T) {
+	family := "\U0001F468\u200D\U0001F469\u200D\U0001F467"
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"\uFEFF{\"a\": 1}", "{\"a\": 1}"},
+		{"\uFEFF\uFEFFtext", "text"},
+		{"\uFEFF" + family, family},
+		{"a\uFEFFb", "a\uFEFFb"},
+		{family + " family", family + " family"},
+	}
+	for _, tt := range tests {
+		if got := Fix(tt.input); got != tt.want {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
 	opts := DefaultOptions()
-	opts.RemoveTerminalEscapes = true
-	got := FixWithOptions("\x1b[31mred\x1b[0m", opts)
-	if got != "red" {
-		t.Errorf("terminal escape removal: got %q, want %q", got, "red")
+	opts.StripBOM = false
+	if got := FixWithOptions("\uFEFFtext", opts); got != "\uFEFFtext" {
+		t.Errorf("FixWithOptions(%q, StripBOM false) = %q, want unchanged", "\uFEFFtext", got)
+	}
+}
+
+func TestRegressed(t *testing.T) {
+	tests := []struct {
+		original string
+		fixed    string
+		want     bool
+	}{
+		{"SÃ£o Paulo", "São Paulo", false},
+		{"caf&eacute;", "café", false},
+		{"it's 10-20", "it\u2019s 10\u201320", false},
+		{"unchanged", "unchanged", false},
+		{"café", "cafÃ©", true},
+		{"it’s", "itâ€™s", true},
+		{"naïve", "na\uFFFDve", true},
+		{"(c) 2024", "\u00A9\u00A9 2024", true},
+	}
+	for _, tt := range tests {
+		if got := Regressed(tt.original, tt.fixed); got != tt.want {
+			t.Errorf("Regressed(%q, %q) = %v, want %v", tt.original, tt.fixed, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteStyle(t *testing.T) {
+	input := "Il a dit «\u00A0oui\u00A0» puis \u201Cnon\u201D, „ja“ ‹x›"
+	tests := []struct {
+		style QuoteStyle
+		want  string
+	}{
+		{QuoteASCII, "Il a dit \"\u00A0oui\u00A0\" puis \"non\", \"ja\" <x>"},
+		{QuotePreserveGuillemets, "Il a dit «\u00A0oui\u00A0» puis \"non\", \"ja\" ‹x›"},
+		{QuoteCurlyToStraight, "Il a dit «\u00A0oui\u00A0» puis \"non\", „ja\" ‹x›"},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.FixCurlyQuotes = true
+		opts.QuoteStyle = tt.style
+		if got := FixWithOptions(input, opts); got != tt.want {
+			t.Errorf("FixWithOptions(%q, QuoteStyle %d) = %q, want %q", input, tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		input    string
+		encoding string
+		fix      bool
+		minConf  float64
+	}{
+		{"plain ASCII", "utf-8", false, 1},
+		{"café déjà vu", "utf-8", false, 1},
+		{"cafÃ© dÃ©jÃ\u00A0 vu", "iso-8859-1", true, 0.9},
+		{"itâ€™s fine", "windows-1252", true, 0.9},
+		{"РїСЂРёРІРµС‚", "windows-1251", true, 0.9},
+	}
+	for _, tt := range tests {
+		got, err := DetectEncoding(tt.input)
+		if err != nil {
+			t.Errorf("DetectEncoding(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got.Encoding != tt.encoding || got.FixRecommended != tt.fix || got.Confidence < tt.minConf || got.Confidence > 1 {
+			t.Errorf("DetectEncoding(%q) = %+v, want Encoding %q, FixRecommended %v, Confidence >= %v",
+				tt.input, got, tt.encoding, tt.fix, tt.minConf)
+		}
+	}
+
+	if _, err := DetectEncoding("bad\xffbyte"); !errors.Is(err, ErrInvalidUTF8) {
+		t.Errorf("DetectEncoding(invalid UTF-8) error = %v, want ErrInvalidUTF8", err)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"plain ASCII", nil},
+		{"Göteborg café", nil},
+		{"cafÃ© dÃ©jÃ\u00A0 vu", []string{"utf8-as-latin1"}},
+		{"itâ€™s", []string{"utf8-as-cp1252"}},
+		{"РїСЂРёРІРµС‚", []string{"utf8-as-cp1251"}},
+		{"cafÃƒÂ©", []string{"utf8-as-cp1252", "double-encoded"}},
+		{"x\xed\xa0\xbdy", []string{"unpaired-surrogates"}},
+		{"\xed\xa0\xbd\xed\xb8\x80", nil},
+		{"\xed\xb8\x80\xed\xa0\xbd\xed\xb8\x80", []string{"unpaired-surrogates"}},
+		{"price\u0080", []string{"c1-controls"}},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.input); !slices.Equal(got, tt.want) {
+			t.Errorf("Classify(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFixAndEncode(t *testing.T) {
+	got, err := FixAndEncode("cafÃ©", "windows-1252")
+	if err != nil || string(got) != "caf\xe9" {
+		t.Fatalf("FixAndEncode(%q) = %q, %v, want %q", "cafÃ©", got, err, "caf\xe9")
+	}
+	back, err := charmap.Windows1252.NewDecoder().Bytes(got)
+	if err != nil || string(back) != "café" {
+		t.Errorf("decoding %q as windows-1252 = %q, %v, want %q", got, back, err, "café")
+	}
+
+	tests := []struct {
+		policy UnmappablePolicy
+		want   string
+	}{
+		{UnmappableReplace, "caf\xe9 ? \x80"},
+		{UnmappableDrop, "caf\xe9  \x80"},
+	}
+	for _, tt := range tests {
+		got, err := FixAndEncodeWith("café 😀 €", "windows-1252", tt.policy)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("FixAndEncodeWith(policy %d) = %q, %v, want %q", tt.policy, got, err, tt.want)
+		}
+	}
+	if _, err := FixAndEncodeWith("café 😀", "windows-1252", UnmappableError); !errors.Is(err, ErrUnmappable) {
+		t.Errorf("FixAndEncodeWith(UnmappableError) error = %v, want ErrUnmappable", err)
+	}
+	if _, err := FixAndEncode("café", "no-such-charset"); err == nil {
+		t.Error("FixAndEncode(unknown charset) = nil error, want error")
+	}
+}
+
+func TestFixMultipartForm(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("name", "JosÃ© GarcÃ\u00ADa")
+	mw.WriteField("tags", "cafÃ©")
+	mw.WriteField("tags", "plain")
+	fw, _ := mw.CreateFormFile("upload", "notes.txt")
+	fw.Write([]byte("cafÃ©"))
+	mw.Close()
+
+	form, err := multipart.NewReader(&body, mw.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer form.RemoveAll()
+	FixMultipartForm(form, DefaultOptions())
+
+	want := map[string][]string{"name": {"José García"}, "tags": {"café", "plain"}}
+	if !reflect.DeepEqual(form.Value, want) {
+		t.Errorf("FixMultipartForm values = %q, want %q", form.Value, want)
+	}
+	f, err := form.File["upload"][0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got, _ := io.ReadAll(f); string(got) != "cafÃ©" {
+		t.Errorf("FixMultipartForm file part = %q, want it untouched", got)
+	}
+
+	FixMultipartForm(nil, DefaultOptions())
+}
+
+func TestDetectLayers(t *testing.T) {
+	tests := []struct {
+		input string
+		want  map[int]int
+	}{
+		{"plain text", map[int]int{}},
+		{"café", map[int]int{}},
+		{"cafÃ© dÃ©jÃ\u00A0", map[int]int{1: 3}},
+		{"cafÃ© and naÃƒÂ¯ve", map[int]int{1: 1, 2: 1}},
+		{"itÃ¢â‚¬â„¢s", map[int]int{2: 1}},
+	}
+	for _, tt := range tests {
+		if got := DetectLayers(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DetectLayers(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCountProblems(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"clean text", 0},
+		{"SÃ£o Paulo", 1},
+		{"cafÃ© and cafÃ©", 2},
+		{"a &amp; b", 1},
+		{"one\r\ntwo", 1},
+		{"ab\x01\x02cd\x03", 2},
+		{"NaÃ¯ve cafÃ© &lt;b&gt;", 4},
+		// Same-length substitutions.
+		{"one\u2028two", 1},
+		{"one\u2028two\u2029three", 2},
+	}
+	for _, tt := range tests {
+		if got := CountProblems(tt.input); got != tt.want {
+			t.Errorf("CountProblems(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"\uFEFF\u201CCaf\u00C3\u00A9\u201D  &amp;\u200B\tcr\u00C3\u00A8me\r\nbrulee\x07",
+			"\"Café\" & crème\nbrulee"},
+		{"it\u00E2\u20AC\u2122s   \u00ABfine\u00BB", "it's \"fine\""},
+		{"cafe\u0301", "caf\u00E9"},
+		{"already clean", "already clean"},
+	}
+	for _, tt := range tests {
+		if got := Clean(tt.input); got != tt.want {
+			t.Errorf("Clean(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFixPercentEncoding(t *testing.T) {
+	opts := DefaultOptions()
+	opts.FixPercentEncoding = true
+	opts.MaxDecodePasses = 2
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"caf%C3%83%C2%A9", "café"},
+		{"caf%C3%A9 cr%c3%a8me", "café crème"},
+		{"100%25 off, see a%20b", "100%25 off, see a%20b"},
+		{"bad %C3%28 escape", "bad %C3%28 escape"},
+		{"trailing %C3", "trailing %C3"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, opts); got != tt.want {
+			t.Errorf("FixWithOptions(%q, FixPercentEncoding) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	if got := Fix("caf%C3%83%C2%A9"); got != "caf%C3%83%C2%A9" {
+		t.Errorf("Fix(%q) = %q, want percent-encoding left alone by default", "caf%C3%83%C2%A9", got)
+	}
+}
+
+func TestMultiLayerMojibake(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"cafÃƒÂ©", "café"},
+		{"cafÃƒÆ’Ã‚Â©", "café"},
+		{"caf\u00C3\u0083\u00C2\u0083\u00C3\u0082\u00C2\u00A9", "café"},
+		{"itÃ¢â‚¬â„¢s", "it’s"},
+		// Valid text must not be over-corrected.
+		{"café", "café"},
+		{"naïve résumé — “quoted”", "naïve résumé — “quoted”"},
+		{"Ärger über Öl in Ålesund", "Ärger über Öl in Ålesund"},
+	}
+	for _, tt := range tests {
+		if got := Fix(tt.input); got != tt.want {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	// A cap lower than the depth leaves the text alone rather than half-decoding it.
+	opts := DefaultOptions()
+	opts.MaxDecodePasses = 2
+	if got := FixWithOptions("cafÃƒÆ’Ã‚Â©", opts); got != "cafÃƒÆ’Ã‚Â©" {
+		t.Errorf("FixWithOptions(%q, MaxDecodePasses 2) = %q, want unchanged", "cafÃƒÆ’Ã‚Â©", got)
+	}
+}
+
+func TestQuickFixWith(t *testing.T) {
+	custom := PatternTable{
+		{"SmÃ¸rrebrÃ¸d Deluxe", "Smørrebrød Deluxe™"},
+		{"Â©", "(c)"},
+	}
+	table := NewPatternTable(custom)
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Buy SmÃ¸rrebrÃ¸d Deluxe now", "Buy Smørrebrød Deluxe™ now"},
+		{"Â© 2024 â€” cafÃ©", "(c) 2024 — café"},
+		{"itâ€™s â‚¬5", "it’s €5"},
+	}
+	for _, tt := range tests {
+		if got := QuickFixWith(tt.input, table); got != tt.want {
+			t.Errorf("QuickFixWith(%q, merged) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	if got := QuickFixWith("Â© cafÃ©", custom); got != "(c) cafÃ©" {
+		t.Errorf("QuickFixWith(%q, custom) = %q, want %q", "Â© cafÃ©", got, "(c) cafÃ©")
+	}
+	if len(custom) != 2 || len(table) != len(commonMojibakePatternsOrdered)+1 {
+		t.Errorf("NewPatternTable: len(custom) = %d, len(table) = %d, want 2 and %d",
+			len(custom), len(table), len(commonMojibakePatternsOrdered)+1)
+	}
+	if got := QuickFixWith("itâ€™s", nil); got != "itâ€™s" {
+		t.Errorf("QuickFixWith(%q, nil) = %q, want unchanged", "itâ€™s", got)
+	}
+}
+
+func TestQuickFixLongestMatch(t *testing.T) {
+	table := PatternTable{
+		{"Ã", "[short]"},
+		{"Ã©", "é"},
+		{"Ã©t", "[long]"},
+		{"Ã©", "[duplicate]"},
+		{"é", "[not rescanned]"},
+	}
+	input, want := "Ã©tÃ©Ã!", "[long]é[short]!"
+	if got := QuickFixWith(input, table); got != want {
+		t.Errorf("QuickFixWith(%q) = %q, want %q", input, got, want)
+	}
+	if got := table.Replacer().Replace(input); got != want {
+		t.Errorf("PatternTable.Replacer().Replace(%q) = %q, want %q", input, got, want)
+	}
+
+	big := strings.Repeat("itâ€™s cafÃ©\n", 20000)
+	got, err := QuickFixContext(context.Background(), big)
+	if want := strings.Repeat("it’s café\n", 20000); err != nil || got != want {
+		t.Errorf("QuickFixContext(large input) = %d bytes, %v; want %d bytes", len(got), err, len(want))
+	}
+}
+
+// benchPatternTable is a large table: the built-in patterns plus the
+// mojibake of every Latin-1 and Latin Extended-A letter.
+var benchPatternTable = func() PatternTable {
+	table := NewPatternTable(nil)
+	for r := rune(0xC0); r <= 0x17F; r++ {
+		table = append(table, struct{ Broken, Fixed string }{Mojibakify(string(r), "windows-1252"), string(r)})
+	}
+	return table
+}()
+
+var benchQuickFixText = strings.Repeat("SÃ£o Paulo cafÃ© Å‚Ã³dÅº itâ€™s DvoÅ™Ã¡k plain text here. ", 50)
+
+func BenchmarkQuickFixWith(b *testing.B) {
+	r := benchPatternTable.Replacer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Replace(benchQuickFixText)
+	}
+}
+
+// BenchmarkQuickFixReplaceAll is the previous implementation, one
+// strings.ReplaceAll per pattern, for comparison.
+func BenchmarkQuickFixReplaceAll(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		text := benchQuickFixText
+		for _, p := range benchPatternTable {
+			text = strings.ReplaceAll(text, p.Broken, p.Fixed)
+		}
+	}
+}
+
+func TestFixCapped(t *testing.T) {
+	tests := []struct {
+		input     string
+		maxBytes  int
+		want      string
+		truncated bool
+	}{
+		{"cafÃ©", 5, "café", false},
+		{"cafÃ© crÃ¨me", 6, "café ", true},
+		{"cafÃ©", 4, "caf", true},
+		{"cafe\u0301s", 5, "caf", true},
+		{"cafe\u0301s", 6, "cafe\u0301", true},
+		{"hi \U0001F468\u200D\U0001F469", 10, "hi ", true},
+		{"flag \U0001F1EB\U0001F1F7", 9, "flag ", true},
+		{"anything", 0, "", true},
+	}
+	opts := DefaultOptions()
+	opts.NormalizationForm = ""
+	for _, tt := range tests {
+		got, truncated := FixCapped(tt.input, tt.maxBytes, opts)
+		if got != tt.want || truncated != tt.truncated {
+			t.Errorf("FixCapped(%q, %d) = %q, %v, want %q, %v", tt.input, tt.maxBytes, got, truncated, tt.want, tt.truncated)
+		}
+	}
+}
+
+func TestScientificSymbolMojibake(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"25Â°C", "25°C"},
+		{"Âµm", "µm"},
+		{"5 Â± 0.1 mmÂ²", "5 ± 0.1 mm²"},
+		{"10Â³ cmÂ³, xÂ¹", "10³ cm³, x¹"},
+		{"Â¼ Â½ Â¾ cup", "¼ ½ ¾ cup"},
+		{"3 Ã— 4 Ã· 2", "3 × 4 ÷ 2"},
+		{"Â§ 4, Â¶ 2, 1Âº", "§ 4, ¶ 2, 1º"},
+	}
+	for _, tt := range tests {
+		if got := QuickFix(tt.input); got != tt.want {
+			t.Errorf("QuickFix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+		if got := Fix(tt.input); got != tt.want {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzeStringOffsets(t *testing.T) {
+	input := "héllo\x01 wörld\uFFFD \xff!"
+	type pos struct {
+		category           string
+		byteOffset, runeIx int
+	}
+	want := []pos{
+		{"control_C0", 6, 5},
+		{"replacement_char", 14, 12},
+		{"replacement_char", 18, 14},
+	}
+	var got []pos
+	for _, info := range AnalyzeString(input) {
+		got = append(got, pos{info.Category, info.ByteOffset, info.RuneIndex})
+		if r, _ := utf8.DecodeRuneInString(input[info.ByteOffset:]); r != info.Rune {
+			t.Errorf("AnalyzeString(%q): input[%d:] starts with %q, want %q", input, info.ByteOffset, r, info.Rune)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeString(%q) positions = %v, want %v", input, got, want)
+	}
+}
+
+func TestAnalyzeStringMojibake(t *testing.T) {
+	clean := []string{"Göteborg", "Malmö och Åre", "Ärger über Öl", "Übung", "âme"}
+	for _, s := range clean {
+		if problems := AnalyzeString(s); len(problems) != 0 {
+			t.Errorf("AnalyzeString(%q) = %+v, want no problems", s, problems)
+		}
+	}
+
+	tests := []struct {
+		input string
+		want  rune
+	}{
+		{"cafÃ©", 'Ã'},
+		{"donâ€™t", 'â'},
+		{"GÃ¶teborg", 'Ã'},
+		{"Ä", 'Ä'},
+	}
+	for _, tt := range tests {
+		problems := AnalyzeString(tt.input)
+		if len(problems) == 0 || problems[0].Category != "likely_mojibake" || problems[0].Rune != tt.want {
+			t.Errorf("AnalyzeString(%q) = %+v, want likely_mojibake at %q", tt.input, problems, tt.want)
+		}
+	}
+}
+
+func TestFoldForSearch(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"ＡＢＣ １２３", "abc 123"},
+		{"Ｏｒｄｅｒ　＃４２", "order #42"},
+		{"\uFB01nancial o\uFB03ce", "financial office"},
+		{"CAFÉ Café café", "café café café"},
+		{"Straße STRASSE", "strasse strasse"},
+		{"cafe\u0301", "café"},
+		{"  lots\tof \n\n space  ", "lots of space"},
+	}
+	for _, tt := range tests {
+		if got := FoldForSearch(tt.input); got != tt.want {
+			t.Errorf("FoldForSearch(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFixWithTrace(t *testing.T) {
+	type event struct {
+		stage          string
+		offset         int
+		removed, added string
+	}
+	input := "cafÃ© &amp; \x01ok\r\nbye"
+	var got []event
+	fixed := FixWithTrace(input, DefaultOptions(), func(stage string, off int, removed, added string) {
+		got = append(got, event{stage, off, removed, added})
+	})
+	if want := "café & ok\nbye"; fixed != want {
+		t.Errorf("FixWithTrace(%q) = %q, want %q", input, fixed, want)
+	}
+	want := []event{
+		{"encoding", 3, "Ã©", "é"},
+		{"html_entities", 9, "amp;", ""},
+		{"line_breaks", 17, "\r", ""},
+		{"control_chars", 14, "\x01", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FixWithTrace(%q) events = %q, want %q", input, got, want)
+	}
+	for _, e := range got {
+		if !strings.HasPrefix(input[e.offset:], e.removed) {
+			t.Errorf("input[%d:] = %q, want it to start with %q", e.offset, input[e.offset:], e.removed)
+		}
+	}
+}
+
+func TestTidyPunctuationSpacing(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"word — word", "word — word"},
+		{"word  —word", "word — word"},
+		{"word—\tword", "word — word"},
+		{"word—word", "word—word"},
+		{"— Bonjour,\nsaid he —\nthen left", "— Bonjour,\nsaid he —\nthen left"},
+		{"The end.Next one!  Then?Done", "The end. Next one! Then? Done"},
+		{"(see below).Then \"quoted.\"Next", "(see below). Then \"quoted.\" Next"},
+		{"a.b e.g. 3.14 example.com U.S.A.", "a.b e.g. 3.14 example.com U.S.A."},
+	}
+	opts := Options{TidyPunctuationSpacing: true}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, opts); got != tt.want {
+			t.Errorf("FixWithOptions(%q, TidyPunctuationSpacing) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	// Delimiting tabs and allowlisted runes are never respaced.
+	kept := []struct {
+		opts        Options
+		input, want string
+	}{
+		{Options{TidyPunctuationSpacing: true, TabIsDelimiter: true}, "a\t—\tb\tend.\tNext", "a\t—\tb\tend.\tNext"},
+		{Options{TidyPunctuationSpacing: true, TabIsDelimiter: true}, "a  —\tb end.  Next", "a —\tb end. Next"},
+		{Options{TidyPunctuationSpacing: true, Allowlist: func(r rune) bool { return r == '\t' }}, "end.\tNext —\tx", "end.\tNext —\tx"},
+		{Options{TidyPunctuationSpacing: true, Allowlist: func(r rune) bool { return r == ' ' }}, "end.   Next  —x", "end.   Next  — x"},
+	}
+	for _, tt := range kept {
+		if got := FixWithOptions(tt.input, tt.opts); got != tt.want {
+			t.Errorf("FixWithOptions(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	opts := DefaultOptions()
+	input := "cafÃ© &amp; <b>bold</b>\r\n"
+	if got, want := ApplyPipeline(input, opts.Pipeline()), FixWithOptions(input, opts); got != want {
+		t.Errorf("ApplyPipeline(%q, default pipeline) = %q, want %q", input, got, want)
+	}
+
+	// Drop HTML entity decoding and insert a tag stripper after encoding repair.
+	var stages []Stage
+	for _, st := range opts.Pipeline() {
+		if st.Name == "html_entities" {
+			continue
+		}
+		stages = append(stages, st)
+		if st.Name == "encoding" {
+			stages = append(stages, Stage{Name: "strip_tags", Fn: func(s string) string {
+				return strings.NewReplacer("<b>", "", "</b>", "").Replace(s)
+			}})
+		}
+	}
+	if got, want := ApplyPipeline(input, stages), "café &amp; bold\n"; got != want {
+		t.Errorf("ApplyPipeline(%q, custom) = %q, want %q", input, got, want)
+	}
+
+	if got := ApplyPipeline(input, nil); got != input {
+		t.Errorf("ApplyPipeline(%q, nil) = %q, want unchanged", input, got)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	input := "cafÃ© &amp; crÃ¨me"
+	res := Inspect(input, DefaultOptions())
+	if want := Fix(input); res.Fixed != want {
+		t.Errorf("Inspect(%q).Fixed = %q, want %q", input, res.Fixed, want)
+	}
+	if !res.Changed {
+		t.Errorf("Inspect(%q).Changed = false, want true", input)
+	}
+	if want := []string{"encoding", "html_entities"}; !reflect.DeepEqual(res.Stages, want) {
+		t.Errorf("Inspect(%q).Stages = %q, want %q", input, res.Stages, want)
+	}
+	if len(res.Problems) != 2 || res.Problems[0].Category != "mojibake" || res.Problems[0].Text != "Ã©" {
+		t.Errorf("Inspect(%q).Problems = %+v, want the two mojibake sequences", input, res.Problems)
+	}
+	if res.DetectedEncoding != "iso-8859-1" || res.Confidence <= 0.5 {
+		t.Errorf("Inspect(%q) encoding = %q (%.2f), want iso-8859-1 with confidence above 0.5", input, res.DetectedEncoding, res.Confidence)
+	}
+
+	clean := Inspect("clean text", DefaultOptions())
+	if clean.Fixed != "clean text" || clean.Changed || clean.Stages != nil || clean.Problems != nil ||
+		clean.DetectedEncoding != "utf-8" || clean.Confidence != 1 {
+		t.Errorf("Inspect(%q) = %+v, want an unchanged, problem-free result", "clean text", clean)
+	}
+}
+
+func TestHasSurrogates(t *testing.T) {
+	if HasSurrogates("clean text") {
+		t.Error("should not detect surrogates in clean text")
+	}
+}
+
+func TestHasReplacementChars(t *testing.T) {
+	if !HasReplacementChars("bad\uFFFDtext") {
+		t.Error("should detect replacement char")
+	}
+}
+
+func TestReplacementChars(t *testing.T) {
+	tests := []struct {
+		input   string
+		offsets []int
+	}{
+		{"clean", nil},
+		{"bad\uFFFDtext", []int{3}},
+		{"\uFFFD\uFFFDé\uFFFD", []int{0, 3, 8}},
+		{"bad\xffbyte", nil},
+	}
+	for _, tt := range tests {
+		if got := CountReplacementChars(tt.input); got != len(tt.offsets) {
+			t.Errorf("CountReplacementChars(%q) = %d, want %d", tt.input, got, len(tt.offsets))
+		}
+		if got := ReplacementCharOffsets(tt.input); !slices.Equal(got, tt.offsets) {
+			t.Errorf("ReplacementCharOffsets(%q) = %v, want %v", tt.input, got, tt.offsets)
+		}
+	}
+}
+
+func TestRemoveTerminalEscapes(t *testing.T) {
+	opts := DefaultOptions()
+	opts.RemoveTerminalEscapes = true
+	got := FixWithOptions("\x1b[31mred\x1b[0m", opts)
+	if got != "red" {
+		t.Errorf("terminal escape removal: got %q, want %q", got, "red")
+	}
+}
+
+func TestTabIsDelimiter(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CollapseWhitespace = true
+
+	got := FixWithOptions("a\t\tb   c", opts)
+	if got != "a b c" {
+		t.Errorf("collapse without TabIsDelimiter: got %q, want %q", got, "a b c")
+	}
+
+	opts.TabIsDelimiter = true
+	input := "cafÃ©\t\tSÃ£o   Paulo\t\x01x\t"
+	expected := "café\t\tSão Paulo\tx\t"
+	got = FixWithOptions(input, opts)
+	if got != expected {
+		t.Errorf("collapse with TabIsDelimiter: got %q, want %q", got, expected)
+	}
+}
+
+func TestFixDistance(t *testing.T) {
+	if d := FixDistance("hello", DefaultOptions()); d != 0 {
+		t.Errorf("FixDistance(clean) = %d, want 0", d)
+	}
+	small := FixDistance("cafÃ©", DefaultOptions())
+	if small != 2 {
+		t.Errorf("FixDistance(single mojibake) = %d, want 2", small)
+	}
+	large := FixDistance("rÃ©sumÃ© from SÃ£o Paulo, naÃ¯ve cafÃ©", DefaultOptions())
+	if large <= small {
+		t.Errorf("FixDistance(heavy corruption) = %d, want more than %d", large, small)
+	}
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"kitten", "sitting", 3},
+		{"prefix kitten suffix", "prefix sitting suffix", 3},
+		{"aaa", "aa", 1},
+		{"abcabc", "abc", 3},
+		{"", "abc", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	// A local fix in a long text costs no more than the text's length.
+	long := strings.Repeat("clean text ", 20000)
+	start := time.Now()
+	if d := FixDistance(long+"cafÃ©"+long, DefaultOptions()); d != 2 {
+		t.Errorf("FixDistance(long text with one mojibake) = %d, want 2", d)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("FixDistance on %d bytes took %v, want well under a second", 2*len(long), elapsed)
+	}
+}
+
+func TestPartialReMojibakeLeftUnchanged(t *testing.T) {
+	// One decode pass would yield "Ã©Ã", which still looks like mojibake
+	// and cannot be decoded further.
+	input := "Ã\u0083Â©Ã\u0083"
+	if got := FixWithOptions(input, Options{FixEncoding: true}); got != input {
+		t.Errorf("FixWithOptions(%q) = %q, want input unchanged", input, got)
+	}
+}
+
+func TestLoneCircumflexNotMojibake(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"âme", "âme"},
+		{"Âge d'or", "Âge d'or"},
+		{"Việt Nam: â", "Việt Nam: â"},
+		{"â€™", "’"},
+		{"Lâ€™âme", "L’âme"},
+	}
+	for _, tt := range tests {
+		if got := Fix(tt.input); got != tt.want {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	if looksLikeMojibake("âme") {
+		t.Errorf("looksLikeMojibake(%q) = true, want false", "âme")
+	}
+}
+
+func TestAnalyzeStringEmojiSequences(t *testing.T) {
+	clean := []string{
+		"nice \U0001F44D\U0001F3FD",                  // thumbs up, medium skin tone
+		"\U0001F468\u200D\U0001F469\u200D\U0001F467", // family
+		"\u2764\uFE0F",                               // red heart
+		"\U0001F3F3\uFE0F\u200D\U0001F308 and text",  // rainbow flag
+	}
+	for _, s := range clean {
+		if problems := AnalyzeString(s); len(problems) != 0 {
+			t.Errorf("AnalyzeString(%q) = %+v, want no problems", s, problems)
+		}
+	}
+
+	stray := []struct {
+		input    string
+		category string
+	}{
+		{"a\u200Db", "zero_width_joiner"},
+		{"\uFE0Fstart", "variation_selector"},
+		{"x\U0001F3FD", "emoji_modifier"},
+	}
+	for _, tt := range stray {
+		problems := AnalyzeString(tt.input)
+		if len(problems) != 1 || problems[0].Category != tt.category {
+			t.Errorf("AnalyzeString(%q) = %+v, want one %s", tt.input, problems, tt.category)
+		}
+	}
+}
+
+func TestFixMarkup(t *testing.T) {
+	fixed, markup := FixMarkup("<b>cafÃ©</b>", Options{FixEncoding: true})
+	if fixed != "<b>café</b>" {
+		t.Errorf("FixMarkup fixed = %q, want %q", fixed, "<b>café</b>")
+	}
+	want := "&lt;b&gt;caf<del>Ã©</del><ins>é</ins>&lt;/b&gt;"
+	if string(markup) != want {
+		t.Errorf("FixMarkup markup = %q, want %q", markup, want)
+	}
+
+	_, markup = FixMarkup("plain", DefaultOptions())
+	if string(markup) != "plain" {
+		t.Errorf("FixMarkup(unchanged) markup = %q, want %q", markup, "plain")
+	}
+}
+
+func TestFixEncodedWord(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"=?UTF-8?Q?caf=C3=A9?=", "café"},
+		{"Re: =?UTF-8?Q?caf=C3=83=C2=A9?=", "Re: café"},
+		{"=?ISO-8859-1?Q?S=E3o?= =?UTF-8?B?UGF1bG8=?=", "SãoPaulo"},
+		{"=?windows-1252?Q?=93quoted=94?=", "“quoted”"},
+		{"plain subject", "plain subject"},
+	}
+	for _, tt := range tests {
+		got, err := FixEncodedWord(tt.input)
+		if err != nil {
+			t.Errorf("FixEncodedWord(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("FixEncodedWord(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+
+	if _, err := FixEncodedWord("=?x-no-such-charset?Q?abc?="); err == nil {
+		t.Error("FixEncodedWord with unknown charset: expected error")
+	}
+}
+
+func TestFoldRomanNumerals(t *testing.T) {
+	opts := DefaultOptions()
+	opts.FoldRomanNumerals = true
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Chapter Ⅻ", "Chapter XII"},
+		{"step ⅳ", "step iv"},
+		{"Ⅿ Ⅽ Ⅼ", "M C L"},
+	}
+	for _, tt := range tests {
+		got := FixWithOptions(tt.input, opts)
+		if got != tt.expected {
+			t.Errorf("FixWithOptions(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+	if got := Fix("Ⅻ"); got != "Ⅻ" {
+		t.Errorf("Fix(%q) = %q, want numerals untouched by default", "Ⅻ", got)
+	}
+}
+
+func TestFixBase64(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{base64.StdEncoding.EncodeToString([]byte("cafÃ©")), "café"},
+		{base64.RawURLEncoding.EncodeToString([]byte("SÃ£o Paulo??>")), "São Paulo??>"},
+		{"Y2Fmw4PCqQ==\r\n", "café"},
+	}
+	for _, tt := range tests {
+		got, err := FixBase64(tt.input, DefaultOptions())
+		if err != nil {
+			t.Errorf("FixBase64(%q) error: %v", tt.input, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("FixBase64(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+
+	for _, bad := range []string{"Y2Fmw4PCqQ=", "not base64!"} {
+		if _, err := FixBase64(bad, DefaultOptions()); err == nil {
+			t.Errorf("FixBase64(%q): expected error", bad)
+		}
+	}
+
+	reencoded, err := FixBase64Encoded("Y2Fmw4PCqQ==", DefaultOptions())
+	if err != nil || reencoded != base64.StdEncoding.EncodeToString([]byte("café")) {
+		t.Errorf("FixBase64Encoded = %q, %v; want %q", reencoded, err, base64.StdEncoding.EncodeToString([]byte("café")))
+	}
+}
+
+func TestRemoveDiacritics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"naïve résumé", "naive resume"},
+		{"Crème Brûlée, São Paulo!", "Creme Brulee, Sao Paulo!"},
+		{"Йошкар-Ола", "Йошкар-Ола"},
+		{"Ελλάδα", "Ελλάδα"},
+	}
+	for _, tt := range tests {
+		got := RemoveDiacritics(tt.input)
+		if got != tt.expected {
+			t.Errorf("RemoveDiacritics(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestMustBeClean(t *testing.T) {
+	if err := MustBeClean("Hello, world!"); err != nil {
+		t.Errorf("MustBeClean(clean) = %v, want nil", err)
+	}
+
+	err := MustBeClean("SÃ£o Paulo")
+	if !errors.Is(err, ErrNotClean) {
+		t.Fatalf("MustBeClean(mojibake) = %v, want ErrNotClean", err)
+	}
+	for _, want := range []string{"likely_mojibake", "byte offset 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("MustBeClean(mojibake) error %q does not mention %q", err, want)
+		}
+	}
+
+	entities := []struct {
+		input, want string
+	}{
+		{"AT&amp;T", `"&amp;" would change at byte offset 2`},
+		{"café &lt;b&gt;", `"&lt;" would change at byte offset 6`},
+	}
+	for _, tt := range entities {
+		err = MustBeClean(tt.input)
+		if !errors.Is(err, ErrNotClean) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("MustBeClean(%q) = %v, want ErrNotClean with %s", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestStreamFixer(t *testing.T) {
+	input := "Welcome to SÃ£o Paulo's cafÃ© scene\r\nline two"
+	// Split inside the "Ã£" pattern, in the middle of the bytes of "©",
+	// and between the CR and LF.
+	cut1 := strings.Index(input, "£")
+	cut2 := strings.Index(input, "©") + 1
+	cut3 := strings.Index(input, "\n")
+
+	f := NewStreamFixer(DefaultOptions())
+	var out []byte
+	out = append(out, f.Push([]byte(input[:cut1]))...)
+	out = append(out, f.Push([]byte(input[cut1:cut2]))...)
+	out = append(out, f.Push([]byte(input[cut2:cut3]))...)
+	out = append(out, f.Push([]byte(input[cut3:]))...)
+	out = append(out, f.Flush()...)
+
+	expected := "Welcome to São Paulo's café scene\nline two"
+	if string(out) != expected {
+		t.Errorf("StreamFixer output = %q, want %q", out, expected)
+	}
+
+	f = NewStreamFixer(DefaultOptions())
+	for i := 0; i < 100; i++ {
+		f.Push([]byte(strings.Repeat("a", 100)))
+		if len(f.pending) > streamWindow {
+			t.Fatalf("StreamFixer holds %d bytes, want at most %d", len(f.pending), streamWindow)
+		}
+	}
+}
+
+func TestCountLostBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"clean text", 0},
+		{"X\uFFFDY and A\uFFFDB", 2},
+		{"caf\uFFFD\uFFFD", 2},
+		{"na\uFFFDïve", 2},
+	}
+	for _, tt := range tests {
+		if got := CountLostBytes(tt.input); got != tt.expected {
+			t.Errorf("CountLostBytes(%q) = %d, want %d", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestFixContext(t *testing.T) {
+	got, err := FixContext(context.Background(), "cafÃ©", DefaultOptions())
+	if err != nil || got != "café" {
+		t.Errorf("FixContext = %q, %v; want %q, nil", got, err, "café")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err := FixContext(ctx, strings.Repeat("cafÃ© ", 1000), DefaultOptions()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FixContext(expired) error = %v, want context.DeadlineExceeded", err)
+	}
+	if _, err := QuickFixContext(ctx, "cafÃ©"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("QuickFixContext(expired) error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestNumericDashes(t *testing.T) {
+	opts := DefaultOptions()
+	opts.NumericDashes = true
+
+	fixed := FixWithOptions("−5", opts)
+	if n, err := strconv.Atoi(fixed); err != nil || n != -5 {
+		t.Errorf("Atoi(FixWithOptions(%q)) = %d, %v; want -5", "−5", n, err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"pages 5–10", "pages 5-10"},
+		{"well—known", "well—known"},
+		{"a – b", "a – b"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, opts); got != tt.expected {
+			t.Errorf("FixWithOptions(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestFixNFC(t *testing.T) {
+	fixed, wasNFC := FixNFC("café")
+	if fixed != "café" || !wasNFC {
+		t.Errorf("FixNFC(NFC) = %q, %v; want %q, true", fixed, wasNFC, "café")
+	}
+
+	fixed, wasNFC = FixNFC("cafe\u0301")
+	if fixed != "café" || wasNFC {
+		t.Errorf("FixNFC(NFD) = %q, %v; want %q, false", fixed, wasNFC, "café")
+	}
+}
+
+func TestFixBounded(t *testing.T) {
+	input := "\x01\x02\x03\x04\x05"
+	got, ok := FixBounded(input, 2, DefaultOptions())
+	if ok || got != input {
+		t.Errorf("FixBounded(all controls) = %q, %v; want input preserved, false", got, ok)
+	}
+
+	got, ok = FixBounded("cafÃ©", 2, DefaultOptions())
+	if !ok || got != "café" {
+		t.Errorf("FixBounded(mojibake) = %q, %v; want %q, true", got, ok, "café")
+	}
+}
+
+func TestSegmentByScript(t *testing.T) {
+	got := SegmentByScript("Hello, мир! 東京 ok")
+	want := []Segment{
+		{Script: "Latin", Text: "Hello, ", Offset: 0},
+		{Script: "Cyrillic", Text: "мир! ", Offset: 7},
+		{Script: "CJK", Text: "東京 ", Offset: 15},
+		{Script: "Latin", Text: "ok", Offset: 22},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SegmentByScript = %+v, want %+v", got, want)
+	}
+
+	if got := SegmentByScript("123 !?"); len(got) != 1 || got[0].Script != "Common" {
+		t.Errorf("SegmentByScript(common only) = %+v, want one Common segment", got)
+	}
+
+	if got := Fix("Привет, cafÃ©"); got != "Привет, café" {
+		t.Errorf("Fix(mixed scripts) = %q, want %q", got, "Привет, café")
+	}
+}
+
+func TestStrictAmpersand(t *testing.T) {
+	opts := DefaultOptions()
+	opts.StrictAmpersand = true
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"AT&T", "AT&T"},
+		{"AT&amp;T", "AT&T"},
+		{"&copy2024 Acme", "&copy2024 Acme"},
+		{"It&#8217;s &#x263A;", "It’s ☺"},
+		{"It&#8217s", "It&#8217s"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, opts); got != tt.expected {
+			t.Errorf("FixWithOptions(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+	if got := Fix("&copy2024 Acme"); got != "©2024 Acme" {
+		t.Errorf("Fix(%q) = %q, want lenient decoding by default", "&copy2024 Acme", got)
+	}
+}
+
+func TestNumericEntitiesWithoutSemicolon(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"It&#8217s", "It’s"},
+		{"It&#x2019s", "It’s"},
+		{"don&#x2019t &#8217", "don’t ’"},
+		{"l&#x2019eau", "l’eau"},
+		{"&#x1F600 hi", "😀 hi"},
+		{"caf&#233 ok", "café ok"},
+		{"ok&#8217;s", "ok’s"},
+	}
+	for _, tt := range tests {
+		if got := Fix(tt.input); got != tt.expected {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestHTMLEntityMode(t *testing.T) {
+	opts := DefaultOptions()
+	opts.HTMLEntityMode = HTMLStrict
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"AT&amp;T", "AT&T"},
+		{"fish & chips", "fish & chips"},
+		{"&notit;", "&notit;"},
+		{"Tom & Jerry &notanentity; &not;", "Tom & Jerry &notanentity; ¬"},
+		{"&semi; &fjlig; &amp", "; fj &amp"},
+		{"&#8217; &#0; &#xD800; &#x110000;", "’ &#0; &#xD800; &#x110000;"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, opts); got != tt.expected {
+			t.Errorf("FixWithOptions(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+	if got := Fix("&notit;"); got != "¬it;" {
+		t.Errorf("Fix(%q) = %q, want lenient decoding by default", "&notit;", got)
+	}
+}
+
+func TestFixWordSplitFunc(t *testing.T) {
+	sc := bufio.NewScanner(strings.NewReader("cafÃ© São  voilÃ\u00a0\n\x01 naÃ¯ve"))
+	sc.Split(FixWordSplitFunc(DefaultOptions()))
+	var words []string
+	for sc.Scan() {
+		words = append(words, sc.Text())
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"café", "São", "voilà", "naïve"}
+	if !reflect.DeepEqual(words, want) {
+		t.Errorf("scanned words = %q, want %q", words, want)
+	}
+}
+
+func TestRelevantOptions(t *testing.T) {
+	if got := RelevantOptions("plain ASCII text."); len(got) != 0 {
+		t.Errorf("RelevantOptions(plain) = %q, want none", got)
+	}
+	got := RelevantOptions("cafÃ© &amp; co")
+	want := []string{"FixEncoding", "FixHTMLEntities"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RelevantOptions(mojibake+entity) = %q, want %q", got, want)
+	}
+}
+
+func TestFixCorpusReport(t *testing.T) {
+	texts := []string{"clean", "cafÃ©", "also clean", "AT&amp;T\r\n"}
+	var buf bytes.Buffer
+	if err := FixCorpusReport(texts, &buf, DefaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		`{"index":1,"changed":true,"stages":["encoding"],"before":"cafÃ©","after":"café"}`,
+		`{"index":3,"changed":true,"stages":["html_entities","line_breaks"],"before":"AT&amp;T\r\n","after":"AT&T\n"}`,
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("FixCorpusReport output =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRemoveZeroWidth(t *testing.T) {
+	opts := DefaultOptions()
+	opts.RemoveZeroWidth = true
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"ab\u034Fcd", "abcd"},
+		{"zero\u200Bwidth\u2060joined\uFEFF", "zerowidthjoined"},
+		{"a\u0308\u034F\u0301", "\u00E4\u034F\u0301"},
+		{"\U0001F468\u200D\U0001F469", "\U0001F468\u200D\U0001F469"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, opts); got != tt.expected {
+			t.Errorf("FixWithOptions(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestFixUTF16Mojibake(t *testing.T) {
+	const original = "hello there, world"
+	le, _ := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().String(original)
+	be, _ := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder().String(original)
+	if le == be {
+		t.Fatal("expected byte orders to produce different mojibake")
+	}
+
+	for name, broken := range map[string]string{"LE": le, "BE": be} {
+		if got := Fix(broken); got != original {
+			t.Errorf("Fix(UTF-16%s mojibake %q) = %q, want %q", name, broken, got, original)
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.SourceCharset = "utf-16be"
+	if got := FixWithOptions(be, opts); got != original {
+		t.Errorf("FixWithOptions(SourceCharset=utf-16be) = %q, want %q", got, original)
+	}
+	opts.SourceCharset = "utf-16le"
+	if got := FixWithOptions(le, opts); got != original {
+		t.Errorf("FixWithOptions(SourceCharset=utf-16le) = %q, want %q", got, original)
+	}
+
+	for _, cjk := range []string{"我们今天去北京吃饭", "東京都の天気は晴れです"} {
+		if got := Fix(cjk); got != cjk {
+			t.Errorf("Fix(%q) = %q, want CJK text unchanged", cjk, got)
+		}
+	}
+}
+
+func TestMaxEntityExpansions(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxEntityExpansions = 2
+	input := "&#x10FFFF;&amp;&lt;&gt;&copy"
+	expected := "\U0010FFFF&&lt;&gt;&copy"
+	if got := FixWithOptions(input, opts); got != expected {
+		t.Errorf("FixWithOptions(%q) = %q, want %q", input, got, expected)
+	}
+
+	opts.MaxEntityExpansions = 0
+	if got := FixWithOptions(input, opts); got != "\U0010FFFF&<>©" {
+		t.Errorf("FixWithOptions(%q) without limit = %q, want everything decoded", input, got)
+	}
+}
+
+func TestFixProfiled(t *testing.T) {
+	opts := Options{FixEncoding: true, FixHTMLEntities: true}
+	fixed, timings := FixProfiled("cafÃ© &amp; co", opts)
+	if fixed != "café & co" {
+		t.Errorf("FixProfiled fixed = %q, want %q", fixed, "café & co")
+	}
+	if len(timings) != 2 {
+		t.Errorf("FixProfiled timings = %v, want exactly the two enabled stages", timings)
+	}
+	for _, name := range []string{"encoding", "html_entities"} {
+		if _, ok := timings[name]; !ok {
+			t.Errorf("FixProfiled timings missing stage %q", name)
+		}
+	}
+}
+
+func TestCaseInsensitiveEntities(t *testing.T) {
+	opts := DefaultOptions()
+	opts.CaseInsensitiveEntities = true
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"AT&AMP;T", "AT&T"},
+		{"a&NBSP;b", "a\u00a0b"},
+		{"&Eacute;t&eacute;", "Été"},
+		{"&COPY; &NotAnEntity;", "© &NotAnEntity;"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, opts); got != tt.expected {
+			t.Errorf("FixWithOptions(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+	if got := Fix("a&NBSP;b"); got != "a&NBSP;b" {
+		t.Errorf("Fix(%q) = %q, want unknown casing left alone by default", "a&NBSP;b", got)
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	got := graphemes("é\r\n\U0001F44D\U0001F3FD\U0001F1EB\U0001F1F7\U0001F468\u200D\U0001F469x")
+	want := []string{"é", "\r\n", "\U0001F44D\U0001F3FD", "\U0001F1EB\U0001F1F7", "\U0001F468\u200D\U0001F469", "x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("graphemes = %q, want %q", got, want)
+	}
+}
+
+func TestFixAligned(t *testing.T) {
+	fixed, align := FixAligned("cafÃ© ok", DefaultOptions())
+	if fixed != "café ok" {
+		t.Errorf("FixAligned fixed = %q, want %q", fixed, "café ok")
+	}
+	want := [][2]int{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 3}, {5, 4}, {6, 5}, {7, 6}}
+	if !reflect.DeepEqual(align, want) {
+		t.Errorf("FixAligned align = %v, want %v", align, want)
+	}
+
+	_, align = FixAligned("a\x01\x02b", DefaultOptions())
+	want = [][2]int{{0, 0}, {1, 1}, {2, 1}, {3, 1}}
+	if !reflect.DeepEqual(align, want) {
+		t.Errorf("FixAligned(deletion) align = %v, want %v", align, want)
+	}
+}
+
+func TestNormalizeEllipsis(t *testing.T) {
+	tests := []struct {
+		input string
+		style EllipsisStyle
+		want  string
+	}{
+		{"wait... what", EllipsisChar, "wait… what"},
+		{"wait. . . what", EllipsisChar, "wait… what"},
+		{"wait… what", EllipsisChar, "wait… what"},
+		{"wait… what", EllipsisDots, "wait... what"},
+		{"wait... what", EllipsisDots, "wait... what"},
+	}
+	for _, tt := range tests {
+		got := FixWithOptions(tt.input, Options{NormalizeEllipsis: true, EllipsisStyle: tt.style})
+		if got != tt.want {
+			t.Errorf("FixWithOptions(%q, style %d) = %q, want %q", tt.input, tt.style, got, tt.want)
+		}
+	}
+
+	opts := Options{PunctuationMap: map[string]string{"--": "–", "---": "—"}}
+	if got := FixWithOptions("a---b--c", opts); got != "a—b–c" {
+		t.Errorf("FixWithOptions(%q, PunctuationMap) = %q, want %q", "a---b--c", got, "a—b–c")
+	}
+}
+
+func TestFixByteSlices(t *testing.T) {
+	rows := [][]byte{[]byte("plain"), []byte("SÃ£o Paulo"), nil, {}}
+	got := FixByteSlices(rows)
+	want := []string{"plain", "São Paulo", "", ""}
+	for i, row := range got {
+		if string(row) != want[i] {
+			t.Errorf("FixByteSlices row %d = %q, want %q", i, row, want[i])
+		}
+	}
+	if got[2] != nil {
+		t.Errorf("FixByteSlices row 2 = %#v, want nil", got[2])
+	}
+	if got[3] == nil {
+		t.Errorf("FixByteSlices row 3 = nil, want empty slice")
+	}
+	if &got[0][0] != &rows[0][0] {
+		t.Errorf("FixByteSlices copied a row that needed no fixing")
+	}
+	if &got[1][0] != &rows[1][0] {
+		t.Errorf("FixByteSlices allocated a row whose fix fits in place")
+	}
+}
+
+var benchRows = func() [][]byte {
+	rows := make([][]byte, 1000)
+	for i := range rows {
+		if i%10 == 0 {
+			rows[i] = []byte("SÃ£o Paulo cafÃ© rÃ©sumÃ©")
+		} else {
+			rows[i] = []byte("Sao Paulo cafe resume, nothing to fix here")
+		}
+	}
+	return rows
+}()
+
+func BenchmarkFixByteSlices(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		FixByteSlices(benchRows)
+	}
+}
+
+func BenchmarkFixByteSlicesViaString(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		out := make([][]byte, len(benchRows))
+		for j, row := range benchRows {
+			out[j] = []byte(Fix(string(row)))
+		}
+	}
+}
+
+func TestFixBytes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"clean", "clean"},
+		{"SÃ£o Paulo", "São Paulo"},
+		{"\uFEFFcafÃ©", "café"},
+		{"a&#x1F600;", "a\U0001F600"},
+	}
+	for _, tt := range tests {
+		b := []byte(tt.input)
+		got := FixBytes(b, DefaultOptions())
+		if string(got) != tt.want {
+			t.Errorf("FixBytes(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+		if len(got) > 0 && len(got) <= len(b) && &got[0] != &b[0] {
+			t.Errorf("FixBytes(%q) allocated, want the result written over the input", tt.input)
+		}
+	}
+}
+
+var benchPayload = []byte(strings.Repeat("SÃ£o Paulo cafÃ© rÃ©sumÃ© &amp; more text to fix. ", 20))
+
+func BenchmarkFixBytes(b *testing.B) {
+	buf := make([]byte, len(benchPayload))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		copy(buf[:cap(buf)], benchPayload)
+		FixBytes(buf[:len(benchPayload)], DefaultOptions())
+	}
+}
+
+func BenchmarkFixBytesViaString(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = []byte(Fix(string(benchPayload)))
+	}
+}
+
+func TestFixer(t *testing.T) {
+	opts := DefaultOptions()
+	opts.FixCurlyQuotes = true
+	opts.NormalizeEllipsis = true
+	f := NewFixer(opts)
+	inputs := []string{"cafÃ© &amp; co", "“quoted”...", "itâ€™s", "plain"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, input := range inputs {
+				if got, want := f.Fix(input), FixWithOptions(input, opts); got != want {
+					t.Errorf("Fixer.Fix(%q) = %q, want %q", input, got, want)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFixChan(t *testing.T) {
+	var inputs []string
+	for i := 0; i < 500; i++ {
+		// Vary the length so workers finish out of order.
+		inputs = append(inputs, fmt.Sprintf("%d cafÃ©%s", i, strings.Repeat(" itâ€™s", i%37)))
+	}
+	for _, workers := range []int{0, 1, 8} {
+		in := make(chan string)
+		go func() {
+			for _, s := range inputs {
+				in <- s
+			}
+			close(in)
+		}()
+		i := 0
+		for got := range FixChan(in, DefaultOptions(), workers) {
+			if i >= len(inputs) {
+				t.Fatalf("FixChan(workers %d) sent more than %d results", workers, len(inputs))
+			}
+			if want := Fix(inputs[i]); got != want {
+				t.Fatalf("FixChan(workers %d) result %d = %q, want %q", workers, i, got, want)
+			}
+			i++
+		}
+		if i != len(inputs) {
+			t.Errorf("FixChan(workers %d) sent %d results, want %d", workers, i, len(inputs))
+		}
+	}
+
+	in := make(chan string)
+	close(in)
+	if _, ok := <-FixChan(in, DefaultOptions(), 4); ok {
+		t.Error("FixChan(closed input) sent a result, want a closed channel")
+	}
+
+	// Nothing reads the results for a while, so at most 2*workers strings
+	// are in flight plus the one waiting to be dispatched.
+	const workers = 3
+	in = make(chan string)
+	var taken atomic.Int32
+	go func() {
+		for i := 0; i < 100; i++ {
+			in <- "cafÃ©"
+			taken.Add(1)
+		}
+		close(in)
+	}()
+	out := FixChan(in, DefaultOptions(), workers)
+	time.Sleep(50 * time.Millisecond)
+	if n := taken.Load(); n > 2*workers+1 {
+		t.Errorf("FixChan took %d strings with no results read, want at most %d", n, 2*workers+1)
+	}
+	for range out {
+	}
+}
+
+var benchShort = "cafÃ© &amp; co"
+
+func BenchmarkFixer(b *testing.B) {
+	f := NewFixer(DefaultOptions())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.Fix(benchShort)
+	}
+}
+
+func BenchmarkFixRepeated(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Fix(benchShort)
+	}
+}
+
+func TestHasMixedNormalization(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"caf\u00E9 and cafe\u0301", true},
+		{"caf\u00E9 and r\u00E9sum\u00E9", false},
+		{"cafe\u0301 and re\u0301sume\u0301", false},
+		{"plain ASCII", false},
+		{"\u212B and \u00C5", false},
+	}
+	for _, tt := range tests {
+		if got := HasMixedNormalization(tt.input); got != tt.want {
+			t.Errorf("HasMixedNormalization(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	opts := DefaultOptions()
+	opts.RemoveZeroWidth = true
+	opts.Allowlist = func(r rune) bool { return r == '\x1e' || r == '\u200B' }
+
+	input := "rec1\x1erec2\x01\u200B\u2060"
+	want := "rec1\x1erec2\u200B"
+	if got := FixWithOptions(input, opts); got != want {
+		t.Errorf("FixWithOptions(%q, Allowlist) = %q, want %q", input, got, want)
+	}
+	if got := Fix(input); got != "rec1rec2\u200B\u2060" {
+		t.Errorf("Fix(%q) = %q, want record separator stripped", input, got)
+	}
+}
+
+func TestGrade(t *testing.T) {
+	long := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 3)
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "A"},
+		{"São Paulo is clean", "A"},
+		{long + "café", "A"},
+		{long + "cafÃ©", "B"},
+		{"SÃ£o Paulo Ã© um cafÃ©", "F"},
+		{"lost \uFFFD\uFFFD\uFFFD bytes", "F"},
+	}
+	for _, tt := range tests {
+		if got := Grade(tt.input); got != tt.want {
+			t.Errorf("Grade(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+
+	// Grading must stay roughly linear: a scorecard runs it on whole files.
+	huge := strings.Repeat(long, 400)
+	start := time.Now()
+	for _, tt := range []struct{ input, want string }{
+		{huge, "A"},
+		{huge + "cafÃ©" + huge, "B"},
+		{strings.Repeat("cafÃ© ", 10000), "F"},
+	} {
+		if got := Grade(tt.input); got != tt.want {
+			t.Errorf("Grade(%d bytes) = %q, want %q", len(tt.input), got, tt.want)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Grade on large inputs took %v, want well under a second", elapsed)
+	}
+}
+
+func TestFixSliceWithOriginals(t *testing.T) {
+	input := []string{"clean", "SÃ£o Paulo", "also clean", "cafÃ©"}
+	fixed, originals := FixSliceWithOriginals(input)
+	wantFixed := []string{"clean", "São Paulo", "also clean", "café"}
+	if !reflect.DeepEqual(fixed, wantFixed) {
+		t.Errorf("FixSliceWithOriginals fixed = %q, want %q", fixed, wantFixed)
+	}
+	wantOriginals := map[int]string{1: "SÃ£o Paulo", 3: "cafÃ©"}
+	if !reflect.DeepEqual(originals, wantOriginals) {
+		t.Errorf("FixSliceWithOriginals originals = %q, want %q", originals, wantOriginals)
+	}
+}
+
+func TestFixUTF7(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"caf+AOk-", "café"},
+		{"caf+AOk au lait", "café au lait"},
+		{"Hi Mom -+Jjo--!", "Hi Mom -☺-!"},
+		{"+ZeVnLIqe-", "日本語"},
+		{"+2D3eAA-", "\U0001F600"},
+		{"1 +- 1", "1 + 1"},
+		{"call +15550100", "call +15550100"},
+		{"C++ and A+B", "C++ and A+B"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, Options{FixUTF7: true}); got != tt.want {
+			t.Errorf("FixWithOptions(%q, FixUTF7) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	if got := Fix("caf+AOk-"); got != "caf+AOk-" {
+		t.Errorf("Fix(%q) = %q, want UTF-7 left alone by default", "caf+AOk-", got)
+	}
+}
+
+func TestFixBudget(t *testing.T) {
+	input := "cafÃ© &amp; bar\r\n"
+	tests := []struct {
+		maxStages int
+		want      string
+	}{
+		{0, input},
+		{1, "café &amp; bar\r\n"},
+		{2, "café & bar\r\n"},
+		{100, Fix(input)},
+	}
+	for _, tt := range tests {
+		if got := FixBudget(input, DefaultOptions(), tt.maxStages); got != tt.want {
+			t.Errorf("FixBudget(%q, %d) = %q, want %q", input, tt.maxStages, got, tt.want)
+		}
+	}
+	for name := range stageNotes {
+		if !slices.Contains(stagePriority, name) {
+			t.Errorf("stage %q has no FixBudget priority", name)
+		}
+	}
+}
+
+func TestFixSurrogatePairs(t *testing.T) {
+	// CESU-8 encodes U+1F600 as the surrogates D83D DE00: ED A0 BD ED B8 80.
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"\xed\xa0\xbd\xed\xb8\x80", "\U0001F600"},
+		{"smile \xed\xa0\xbd\xed\xb8\x80 ok", "smile \U0001F600 ok"},
+		{"\xed\xa0\x81\xed\xb0\x80", "\U00010400"},
+		{"ends high \xed\xa0\xbd", "ends high \uFFFD"},
+		{"\xed\xa0\xbd\xed\xb8\x80\xed\xa0\xbd", "\U0001F600\uFFFD"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, Options{FixSurrogates: true}); got != tt.want {
+			t.Errorf("FixWithOptions(%q, FixSurrogates) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFixSwappedSurrogates(t *testing.T) {
+	// U+1F600 is D83D DE00; in WTF-8 that is ED A0 BD ED B8 80.
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"hi \xed\xa0\xbd\xed\xb8\x80!", "hi \U0001F600!"},
+		{"hi \xed\xb8\x80\xed\xa0\xbd!", "hi \U0001F600!"},
+		{"\xed\xa0\xbd\xed\xb8\x80\xed\xa0\xbd\xed\xb8\x80", "\U0001F600\U0001F600"},
+		{"two highs \xed\xa0\xbd\xed\xa0\xbd", "two highs \uFFFD\uFFFD"},
+		{"lone \xed\xb8\x80 low", "lone \uFFFD low"},
+		{"lone \xed\xa0\xbd high", "lone \uFFFD high"},
+		{"two lows \xed\xb8\x80\xed\xb8\x80", "two lows \uFFFD\uFFFD"},
+		{"low high low \xed\xb8\x80\xed\xa0\xbd\xed\xb8\x80", "low high low \uFFFD\U0001F600"},
+		{"high low high \xed\xa0\xbd\xed\xb8\x80\xed\xa0\xbd", "high low high \U0001F600\uFFFD"},
+		{"bad \xff byte", "bad \uFFFD byte"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, Options{FixSurrogates: true}); got != tt.want {
+			t.Errorf("FixWithOptions(%q, FixSurrogates) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	if !HasSurrogates("hi \xed\xb8\x80") {
+		t.Errorf("HasSurrogates(%q) = false, want true", "hi \xed\xb8\x80")
+	}
+}
+
+func TestEnableExpvar(t *testing.T) {
+	EnableExpvar()
+	EnableExpvar() // a second call must not panic on re-publishing
+	stats := expvar.Get("goftfy").(*expvar.Map)
+	counter := func(m *expvar.Map, key string) int64 {
+		if v, ok := m.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	stages := stats.Get("stages").(*expvar.Map)
+	fixes, nbytes, encoding := counter(stats, "fixes"), counter(stats, "bytes"), counter(stages, "encoding")
+
+	Fix("cafÃ©")
+	Fix("clean")
+
+	if got := counter(stats, "fixes") - fixes; got != 2 {
+		t.Errorf("fixes increased by %d, want 2", got)
+	}
+	if got := counter(stats, "bytes") - nbytes; got != int64(len("cafÃ©")+len("clean")) {
+		t.Errorf("bytes increased by %d, want %d", got, len("cafÃ©")+len("clean"))
+	}
+	if got := counter(stages, "encoding") - encoding; got != 1 {
+		t.Errorf("stages[encoding] increased by %d, want 1", got)
+	}
+}
+
+func TestScanPatterns(t *testing.T) {
+	got := ScanPatterns("cafÃ© rÃ©sumÃ© itâ€™s SÃ£o")
+	want := []PatternMatch{
+		{"Ã©", "é", 3},
+		{"â€™", "’", 1},
+		{"Ã£", "ã", 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanPatterns = %v, want %v", got, want)
+	}
+	if got := ScanPatterns("plain café"); got != nil {
+		t.Errorf("ScanPatterns(%q) = %v, want nil", "plain café", got)
+	}
+}
+
+func TestTopPattern(t *testing.T) {
+	broken, fixed, count := TopPattern("Ã© Ã© Ã© Ã¼ Ã©")
+	if broken != "Ã©" || fixed != "é" || count != 4 {
+		t.Errorf("TopPattern = %q, %q, %d, want %q, %q, 4", broken, fixed, count, "Ã©", "é")
+	}
+	if broken, fixed, count := TopPattern("clean"); broken != "" || fixed != "" || count != 0 {
+		t.Errorf("TopPattern(%q) = %q, %q, %d, want empty", "clean", broken, fixed, count)
+	}
+}
+
+func TestCurrencyMojibake(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"â‚¬99", "€99"},
+		{"Â£50", "£50"},
+		{"Â¥1000", "¥1000"},
+		{"Â¢5", "¢5"},
+		{"â‚¹10", "₹10"},
+		{"price â‚¬99 and Â£50", "price €99 and £50"},
+		{"â‚¬5 naÃ¯ve", "€5 naïve"},
+	}
+	for _, tt := range tests {
+		if got := Fix(tt.input); got != tt.want {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	if got := QuickFix("â‚¬99 Â£50"); got != "€99 £50" {
+		t.Errorf("QuickFix(%q) = %q, want %q", "â‚¬99 Â£50", got, "€99 £50")
+	}
+}
+
+func TestLint(t *testing.T) {
+	got := Lint("cafÃ© ok\x01")
+	want := []Problem{
+		{Offset: 3, Text: "Ã©", Category: "mojibake", Message: `"Ã©" is mojibake for 'é'`},
+		{Offset: 10, Text: "\x01", Category: "control_C0", Message: "control_C0 U+0001"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint = %+v, want %+v", got, want)
+	}
+	if got := Lint("clean text"); got != nil {
+		t.Errorf("Lint(%q) = %+v, want nil", "clean text", got)
+	}
+
+	// An invalid byte is one byte long, not the three of U+FFFD.
+	input := "\xff cafÃ©\x01"
+	got = Lint(input)
+	want = []Problem{
+		{Offset: 0, Text: "\xff", Category: "replacement_char", Message: "replacement_char U+FFFD"},
+		{Offset: 5, Text: "Ã©", Category: "mojibake", Message: `"Ã©" is mojibake for 'é'`},
+		{Offset: 9, Text: "\x01", Category: "control_C0", Message: "control_C0 U+0001"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint(%q) = %+v, want %+v", input, got, want)
+	}
+	for _, p := range got {
+		if !strings.HasPrefix(input[p.Offset:], p.Text) {
+			t.Errorf("Lint(%q): input[%d:] does not start with %q", input, p.Offset, p.Text)
+		}
+	}
+}
+
+func TestRegisterDetector(t *testing.T) {
+	RegisterDetector(DetectorFunc(func(text string) []Problem {
+		var problems []Problem
+		for i := strings.Index(text, "¤"); i >= 0; {
+			problems = append(problems, Problem{Offset: i, Text: "¤", Category: "placeholder", Message: "unfilled placeholder"})
+			next := strings.Index(text[i+1:], "¤")
+			if next < 0 {
+				break
+			}
+			i += 1 + next
+		}
+		return problems
+	}))
+	got := Lint("total: ¤ \x01")
+	want := []Problem{
+		{Offset: 7, Text: "¤", Category: "placeholder", Message: "unfilled placeholder"},
+		{Offset: 10, Text: "\x01", Category: "control_C0", Message: "control_C0 U+0001"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint = %+v, want %+v", got, want)
+	}
+}
+
+func TestFixUnifiedDiff(t *testing.T) {
+	input := "one\ntwo\nthree\ncafÃ©\nfive\nsix\nseven\neight\nnine\nten\neleven\nnaÃ¯ve\n"
+	want := "--- original\n+++ fixed\n" +
+		"@@ -1,7 +1,7 @@\n one\n two\n three\n-cafÃ©\n+café\n five\n six\n seven\n" +
+		"@@ -9,4 +9,4 @@\n nine\n ten\n eleven\n-naÃ¯ve\n+naïve\n"
+	if got := FixUnifiedDiff(input, DefaultOptions()); got != want {
+		t.Errorf("FixUnifiedDiff(%q) =\n%s\nwant\n%s", input, got, want)
+	}
+	if got := FixUnifiedDiff("a\ncafÃ©\nb\nSÃ£o\n", DefaultOptions()); !strings.Contains(got, "@@ -1,4 +1,4 @@\n a\n-cafÃ©\n+café\n b\n-SÃ£o\n+São\n") {
+		t.Errorf("FixUnifiedDiff did not merge nearby changes into one hunk:\n%s", got)
+	}
+	if got := FixUnifiedDiff("clean\n", DefaultOptions()); got != "" {
+		t.Errorf("FixUnifiedDiff(%q) = %q, want empty", "clean\n", got)
+	}
+}
+
+func TestCanonicalOrdering(t *testing.T) {
+	// U+0323 (dot below, class 220) belongs before U+0307 (dot above, class 230).
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"q\u0307\u0323 and a\u0301", "q\u0323\u0307 and a\u0301"},
+		{"s\u0307\u0323", "s\u0323\u0307"},
+		{"\u1E69", "\u1E69"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, Options{CanonicalOrdering: true}); got != tt.want {
+			t.Errorf("FixWithOptions(%q, CanonicalOrdering) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFixForTerminal(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"cafÃ©\n", "café\n"},
+		{"\x1b[2J\x1b]0;pwned\x07title", "0;pwned^Gtitle"},
+		{"over\rwrite", "over\nwrite"},
+		{"nul\x00 byte", "nul^@ byte"},
+		{"red \x1b[31mtext\x1b[0m", "red text"},
+		{"tab\tok", "tab\tok"},
+	}
+	for _, tt := range tests {
+		if got := FixForTerminal(tt.input); got != tt.want {
+			t.Errorf("FixForTerminal(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	if got := caretNotation("a\x1bb\x7fc\u009bd\re"); got != "a^[b^?cM-^[d^Me" {
+		t.Errorf("caretNotation = %q, want %q", got, "a^[b^?cM-^[d^Me")
+	}
+}
+
+func TestFixValue(t *testing.T) {
+	type record struct {
+		Name   string
+		Tags   []string
+		hidden string
+		Next   *record
+	}
+	rec := &record{Name: "cafÃ©", Tags: []string{"SÃ£o"}, hidden: "cafÃ©"}
+	rec.Next = rec // cycles are followed once
+
+	data := map[string][]interface{}{
+		"cities": {"SÃ£o Paulo", 42, nil, []interface{}{"naÃ¯ve", true}},
+		"nested": {map[string]interface{}{"k": "rÃ©sumÃ©"}, rec},
+	}
+	if err := FixValue(reflect.ValueOf(data)); err != nil {
+		t.Fatalf("FixValue: %v", err)
+	}
+	want := map[string][]interface{}{
+		"cities": {"São Paulo", 42, nil, []interface{}{"naïve", true}},
+		"nested": {map[string]interface{}{"k": "résumé"}, rec},
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("FixValue = %v, want %v", data, want)
+	}
+	if rec.Name != "café" || rec.Tags[0] != "São" || rec.hidden != "cafÃ©" {
+		t.Errorf("FixValue(struct) = %+v, want exported fields fixed and hidden untouched", *rec)
+	}
+
+	if err := FixValue(reflect.ValueOf("cafÃ©")); err == nil {
+		t.Error("FixValue(unsettable string) = nil, want error")
+	}
+
+	// Maps and slices that contain themselves are walked once.
+	m := map[string]interface{}{"k": "cafÃ©"}
+	m["self"] = m
+	list := []interface{}{"naÃ¯ve", nil}
+	list[1] = list
+	m["list"] = list
+	if err := FixValue(reflect.ValueOf(m)); err != nil {
+		t.Fatalf("FixValue(cyclic map): %v", err)
+	}
+	if m["k"] != "café" || list[0] != "naïve" {
+		t.Errorf("FixValue(cyclic) = %q, %q, want %q, %q", m["k"], list[0], "café", "naïve")
+	}
+}
+
+func TestFixStruct(t *testing.T) {
+	type author struct {
+		Name string
+	}
+	type post struct {
+		Title    string
+		Body     string `goftfy:"curly"`
+		Raw      string `goftfy:"-"`
+		Author   *author
+		Comments []author
+		Meta     map[string]string
+		draft    string
+	}
+	p := post{
+		Title:    "cafÃ© “menu”",
+		Body:     "cafÃ© “menu”",
+		Raw:      "cafÃ©",
+		Author:   &author{Name: "JosÃ©"},
+		Comments: []author{{Name: "ZoÃ«"}},
+		Meta:     map[string]string{"city": "SÃ£o Paulo"},
+		draft:    "cafÃ©",
+	}
+	if err := FixStruct(&p); err != nil {
+		t.Fatalf("FixStruct: %v", err)
+	}
+	want := post{
+		Title:    "café “menu”",
+		Body:     "café \"menu\"",
+		Raw:      "cafÃ©",
+		Author:   &author{Name: "José"},
+		Comments: []author{{Name: "Zoë"}},
+		Meta:     map[string]string{"city": "São Paulo"},
+		draft:    "cafÃ©",
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("FixStruct = %+v, want %+v", p, want)
+	}
+
+	if err := FixStruct(p); err == nil {
+		t.Error("FixStruct(non-pointer) = nil, want error")
+	}
+	bad := struct {
+		S string `goftfy:"bogus"`
+	}{}
+	if err := FixStruct(&bad); err == nil {
+		t.Error("FixStruct(unknown tag option) = nil, want error")
+	}
+}
+
+func TestFixJSON(t *testing.T) {
+	input := `{"city": {"name": "SÃ£o Paulo", "tags": ["cafÃ©", 1, true, null]},
+		"big": 12345678901234567890, "ratio": 1.50, "caf\u00c3\u00a9": "AT&amp;T <b>"}`
+	got, err := FixJSON([]byte(input), DefaultOptions())
+	if err != nil {
+		t.Fatalf("FixJSON: %v", err)
+	}
+	want := `{"big":12345678901234567890,"cafÃ©":"AT&T <b>","city":{"name":"São Paulo","tags":["café",1,true,null]},"ratio":1.50}`
+	if string(got) != want {
+		t.Errorf("FixJSON = %s, want %s", got, want)
+	}
+
+	opts := DefaultOptions()
+	opts.FixKeys = true
+	got, err = FixJSON([]byte(`[{"cafÃ©": "x"}]`), opts)
+	if err != nil || string(got) != `[{"café":"x"}]` {
+		t.Errorf("FixJSON with FixKeys = %s, %v, want %s", got, err, `[{"café":"x"}]`)
+	}
+	if _, err := FixJSON([]byte(`{"cafÃ©": 1, "café": 2}`), opts); err == nil {
+		t.Error("FixJSON with colliding fixed keys = nil error, want error")
+	}
+	for _, bad := range []string{`{"a":`, `{} {}`} {
+		if _, err := FixJSON([]byte(bad), DefaultOptions()); err == nil {
+			t.Errorf("FixJSON(%q) = nil error, want error", bad)
+		}
+	}
+}
+
+func TestFixLinesStripsLineStartBOM(t *testing.T) {
+	input := "\uFEFFid,name\n\uFEFF1,cafÃ©\n\u200B2,ok\uFEFF"
+	want := "id,name\n1,café\n2,ok\uFEFF"
+	if got := FixLines(input); got != want {
+		t.Errorf("FixLines(%q) = %q, want %q", input, got, want)
+	}
+}
+
+func TestFixStageMask(t *testing.T) {
+	fixed, mask := FixStageMask("SÃ£o Paulo", DefaultOptions())
+	if fixed != "São Paulo" {
+		t.Errorf("FixStageMask fixed = %q, want %q", fixed, "São Paulo")
+	}
+	if !mask["encoding"] {
+		t.Errorf("FixStageMask mask[encoding] = false, want true")
+	}
+	if changed, ok := mask["line_breaks"]; !ok || changed {
+		t.Errorf("FixStageMask mask[line_breaks] = %v, %v, want false, true", changed, ok)
+	}
+	if _, ok := mask["curly_quotes"]; ok {
+		t.Errorf("FixStageMask reported disabled stage curly_quotes")
+	}
+}
+
+func TestSourceEncoding(t *testing.T) {
+	tests := []struct {
+		encoding string
+		input    string
+		expected string
+	}{
+		{"koi8-r", "ÐÒÉ×ÅÔ", "привет"},
+		{"iso-8859-5", "ßàØÒÕâ", "привет"},
+		{"windows-1252", "It\u0092s \u0093fine\u0094", "It’s “fine”"},
+		{"utf-8", "cafÃ©", "café"},
+		{"koi8-r", "already привет", "already привет"},
+		{"utf-8", "naïve", "naïve"},
+		{"no-such-charset", "cafÃ©", "café"},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.SourceEncoding = tt.encoding
+		if got := FixWithOptions(tt.input, opts); got != tt.expected {
+			t.Errorf("FixWithOptions(%q) with SourceEncoding %q = %q, want %q", tt.input, tt.encoding, got, tt.expected)
+		}
+	}
+}
+
+func TestCyrillicGreekMojibake(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"РїСЂРёРІРµС‚", "привет"},
+		{"Р”РѕР±СЂРѕ РїРѕР¶Р°Р»РѕРІР°С‚СЊ РІ РњРѕСЃРєРІСѓ", "Добро пожаловать в Москву"},
+		{"Ð¿Ñ€Ð¸Ð²ÐµÑ‚", "привет"},
+		{"ΞΌΞ΅Ξ³Ξ¬Ξ»Ξ·", "μεγάλη"},
+		{"Ξ\u009aΞ±Ξ»Ξ·ΞΌΞ\u00adΟ\u0081Ξ±", "Καλημέρα"},
+		{"Сёмга из России", "Сёмга из России"},
+		{"ΟΛΥΜΠΙΑΚΟΣ Ξάνθη", "ΟΛΥΜΠΙΑΚΟΣ Ξάνθη"},
+	}
+	for _, tt := range tests {
+		if got := Fix(tt.input); got != tt.want {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLatinExtendedAMojibake(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Å\u0081Ã³dÅº", "Łódź"},
+		{"DvoÅ\u0099Ã¡k", "Dvořák"},
+		{"DvoÅ™Ã¡k", "Dvořák"},
+		{"Å\u009bmiech", "śmiech"},
+		{"Å¼aba", "żaba"},
+		{"Ä\u008ceÅ¡tina", "Čeština"},
+		{"piÄ™kny dzieÅ„", "piękny dzień"},
+		{"Åland och Älvsjö", "Åland och Älvsjö"},
+	}
+	for _, tt := range tests {
+		if got := Fix(tt.input); got != tt.want {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFixEnv(t *testing.T) {
+	input := "# cafÃ© settings\r\n" +
+		"NAME=\"SÃ£o\"\r\n" +
+		"export CITY='naÃ¯ve' # keep Ã© here\n" +
+		"PLAIN=rÃ©sumÃ© # note Ã©\n" +
+		"ESCAPED=\"say \\\"cafÃ©\\\"\"\n" +
+		"\n" +
+		"NOVALUE\n"
+	want := "# cafÃ© settings\r\n" +
+		"NAME=\"São\"\r\n" +
+		"export CITY='naïve' # keep Ã© here\n" +
+		"PLAIN=résumé # note Ã©\n" +
+		"ESCAPED=\"say \\\"café\\\"\"\n" +
+		"\n" +
+		"NOVALUE\n"
+	if got := FixEnv(input, DefaultOptions()); got != want {
+		t.Errorf("FixEnv(%q) = %q, want %q", input, got, want)
+	}
+}
+
+func TestMojibakify(t *testing.T) {
+	tests := []struct {
+		input, charset, want string
+	}{
+		{"café", "windows-1252", "cafÃ©"},
+		{"€5", "windows-1252", "â‚¬5"},
+		{"Łódź", "latin-1", "Å\u0081Ã³dÅº"},
+		{"Łódź", "windows-1252", "Å\u0081Ã³dÅº"},
+		{"café", "no-such-charset", "café"},
+	}
+	for _, tt := range tests {
+		if got := Mojibakify(tt.input, tt.charset); got != tt.want {
+			t.Errorf("Mojibakify(%q, %q) = %q, want %q", tt.input, tt.charset, got, tt.want)
+		}
+	}
+}
+
+// recoverable reports whether s is in the class of text Fix is guaranteed
+// to recover from Mojibakify: NFC text made of ASCII letters, digits, spaces
+// and basic punctuation plus Latin-1 Supplement (U+00C0–U+00FF) and Latin
+// Extended-A (U+0100–U+017F) letters, which does not itself contain
+// something that reads as mojibake. Symbols, other scripts, markup
+// characters and controls are not guaranteed.
+func recoverable(s string) bool {
+	if !utf8.ValidString(s) || norm.NFC.String(s) != s || ScanPatterns(s) != nil {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(" .,;:!?'-", r):
+		case r >= 0xC0 && r <= 0x17F && r != 0xD7 && r != 0xF7:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func FuzzMojibakeRoundTrip(f *testing.F) {
+	for _, s := range []string{
+		"café", "São Paulo", "naïve résumé", "château", "Âge d'or", "àÀ",
+		"ÐÑÞ", "Łódź", "Dvořák", "Ærø", "ÿ", "Œuvre", "Ångström", "Ça va?", "Š",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		if !recoverable(s) {
+			t.Skip()
+		}
+		for _, charset := range []string{"windows-1252", "latin-1"} {
+			broken := Mojibakify(s, charset)
+			if got := Fix(broken); got != s {
+				t.Errorf("Fix(Mojibakify(%q, %q)) = Fix(%q) = %q", s, charset, broken, got)
+			}
+		}
+	})
+}
+
+// idempotencyCorpus mixes clean text with each kind of damage the pipeline
+// repairs, including cases where one stage exposes work for an earlier one.
+var idempotencyCorpus = []string{
+	"plain ASCII", "café", "SÃ£o Paulo", "ÃƒÂ©", "itâ€™s", "AT&amp;T",
+	"&Atilde;&copy;", "caf&Atilde;&copy;", "Ã&copy;", "&amp;amp;amp;", "&#38;lt;",
+	"&amp;Atilde;&amp;copy;", "l&#x2019eau", "line\r\nbreak\u2028", "bell\x07 \x1b[31mred\x1b[0m",
+	"\uFEFFbom", "zero\u200Bwidth", "cafÃ© São  voilÃ\u00A0\n\x01 naÃ¯ve", "РїСЂРёРІРµС‚",
+	"“quoted” ... 'single'", "word —word.Next", "−5 – 10", "Ⅻ", "e\u0301\u0327",
+	"+AOk-", "caf%C3%A9", "\xed\xa0\xbd\xed\xb8\x80", "Â Â Â text",
+}
+
+func TestIdempotent(t *testing.T) {
+	all := DefaultOptions()
+	all.RemoveTerminalEscapes, all.FixUTF7, all.FixPercentEncoding = true, true, true
+	all.RemoveZeroWidth, all.CollapseWhitespace, all.TrimTrailingSpace = true, true, true
+	all.FixCurlyQuotes, all.NormalizeEllipsis, all.NumericDashes = true, true, true
+	all.TidyPunctuationSpacing, all.FoldRomanNumerals, all.NormalizationForm = true, true, "NFKC"
+	smart := all
+	smart.FixCurlyQuotes, smart.SmartenQuotes = false, true
+	for _, opts := range []Options{DefaultOptions(), all, smart} {
+		for _, input := range idempotencyCorpus {
+			once := FixWithOptions(input, opts)
+			if twice := FixWithOptions(once, opts); twice != once {
+				t.Errorf("FixWithOptions(%q) = %q, but fixing that again gives %q", input, once, twice)
+			}
+		}
+	}
+	if got := Fix("&Atilde;&copy;"); got != "é" {
+		t.Errorf("Fix(%q) = %q, want %q", "&Atilde;&copy;", got, "é")
+	}
+}
+
+func TestEntryPointsRunAllRounds(t *testing.T) {
+	opts := DefaultOptions()
+	entryPoints := map[string]func(string) string{
+		"FixProfiled": func(s string) string { fixed, _ := FixProfiled(s, opts); return fixed },
+		"FixBudget":   func(s string) string { return FixBudget(s, opts, len(stagePriority)) },
+		"FixStageMask": func(s string) string {
+			fixed, _ := FixStageMask(s, opts)
+			return fixed
+		},
+		"ExplainWithOptions": func(s string) string { fixed, _ := ExplainWithOptions(s, opts); return fixed },
+		"FixWithTrace": func(s string) string {
+			return FixWithTrace(s, opts, func(string, int, string, string) {})
+		},
+		"FixContext": func(s string) string {
+			fixed, _ := FixContext(context.Background(), s, opts)
+			return fixed
+		},
+		"Inspect":   func(s string) string { return Inspect(s, opts).Fixed },
+		"Fixer.Fix": NewFixer(opts).Fix,
+	}
+	for _, input := range []string{"&Atilde;&copy;", "caf&Atilde;&copy;", "&amp;amp;amp;", "&amp;Atilde;&amp;copy;"} {
+		want := FixWithOptions(input, opts)
+		for name, fix := range entryPoints {
+			if got := fix(input); got != want {
+				t.Errorf("%s(%q) = %q, want %q", name, input, got, want)
+			}
+		}
+		if got := Explain(input, want); strings.Contains(got, "inferred") {
+			t.Errorf("Explain(%q, %q) = %q, want no inferred note", input, want, got)
+		}
+	}
+
+	_, mask := FixStageMask("&Atilde;&copy;", opts)
+	if !mask["html_entities"] || !mask["encoding"] {
+		t.Errorf("FixStageMask(%q) mask = %v, want html_entities and encoding set", "&Atilde;&copy;", mask)
+	}
+	if script := FixAsScript("&Atilde;&copy;", opts); !strings.Contains(script, "/é/g") {
+		t.Errorf("FixAsScript(%q) = %q, want a substitution producing %q", "&Atilde;&copy;", script, "é")
+	}
+}
+
+func TestSmartenQuotes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`"hello"`, "“hello”"},
+		{`she said "don't" twice`, "she said “don’t” twice"},
+		{`'single' and ("nested 'inner'")`, "‘single’ and (“nested ‘inner’”)"},
+		{`back in the '90s`, "back in the ’90s"},
+		{`the dogs' bone`, "the dogs’ bone"},
+	}
+	for _, tt := range tests {
+		if got := FixWithOptions(tt.input, Options{SmartenQuotes: true}); got != tt.want {
+			t.Errorf("FixWithOptions(%q, SmartenQuotes) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+	opts := Options{SmartenQuotes: true, FixCurlyQuotes: true}
+	if got := FixWithOptions("“hi”", opts); got != `"hi"` {
+		t.Errorf("FixWithOptions with both quote options = %q, want straightened", got)
+	}
+}
+
+func TestStreamFixerOnChunk(t *testing.T) {
+	// lowest runs text through a StreamFixer and returns the fixed output and
+	// the lowest confidence reported for any chunk.
+	lowest := func(text string) (string, float64) {
+		var out strings.Builder
+		low := 1.0
+		sf := NewStreamFixer(DefaultOptions())
+		sf.OnChunk = func(fixed string, confidence float64) {
+			out.WriteString(fixed)
+			low = min(low, confidence)
+		}
+		sf.Push([]byte(text))
+		sf.Flush()
+		return out.String(), low
+	}
+
+	if fixed, conf := lowest("all clean here"); fixed != "all clean here" || conf != 1 {
+		t.Errorf("clean stream = %q, confidence %v, want confidence 1", fixed, conf)
+	}
+	if fixed, conf := lowest("SÃ£o cafÃ©"); fixed != "São café" || conf >= 0.9 {
+		t.Errorf("mojibake stream = %q, confidence %v, want confidence below 0.9", fixed, conf)
+	}
+}
+
+func TestFixIfContains(t *testing.T) {
+	triggers := []rune{'Ã', '&', '\r'}
+	decomposed := "cafe\u0301" // NFC would compose this, but there is no trigger
+	if got := FixIfContains(decomposed, triggers, DefaultOptions()); got != decomposed {
+		t.Errorf("FixIfContains(%q) = %q, want unchanged", decomposed, got)
+	}
+	if got := FixIfContains("cafÃ©", triggers, DefaultOptions()); got != "café" {
+		t.Errorf("FixIfContains(%q) = %q, want %q", "cafÃ©", got, "café")
+	}
+	if got := FixIfContains("a &amp; b", nil, DefaultOptions()); got != "a &amp; b" {
+		t.Errorf("FixIfContains(%q, nil) = %q, want unchanged", "a &amp; b", got)
+	}
+}
+
+func TestCollapseNBSPRuns(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"10Â Â €", "10 €"},
+		{"10Â Â Â Â €", "10 €"},
+		{"10Â\u00A0Â\u00A0€", "10\u00A0€"},
+		{"10Â\u00A0€", "10\u00A0€"},
+		{"Â Â", "Â Â"},
+	}
+	for _, tt := range tests {
+		if got := Fix(tt.input); got != tt.want {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFixAsScript(t *testing.T) {
+	got := FixAsScript("cafÃ© &amp; crÃªpes, cafÃ© again", DefaultOptions())
+	want := "# fixed mojibake encoding\n" +
+		"s/cafÃ©/café/g\n" +
+		"s/crÃªpes,/crêpes,/g\n" +
+		"# decoded HTML entities\n" +
+		`s/&amp;/\&/g` + "\n"
+	if got != want {
+		t.Errorf("FixAsScript =\n%s\nwant\n%s", got, want)
+	}
+	if got := FixAsScript("a.b*c [x]", Options{FixCurlyQuotes: true}); got != "" {
+		t.Errorf("FixAsScript(clean) = %q, want empty", got)
+	}
+	if got := sedPattern("a.b*c/[x]$"); got != `a\.b\*c\/\[x\]\$` {
+		t.Errorf("sedPattern = %q", got)
+	}
+}
+
+func TestDecodeWindows1252Mojibake(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"itâ€™s", "it’s"},
+		{"â€œquotedâ€\u009d", "“quoted”"},
+		{"wait â€” what", "wait — what"},
+		{"â‚¬5", "€5"},
+		{"â€žlow quoteâ€œ", "„low quote“"},
+		{"â€¢ bullet", "• bullet"},
+		{"cafÃ© it’s", "café it’s"},
+	}
+	for _, tt := range tests {
+		if got := decodeMojibake(tt.input, Options{}); got != tt.want {
+			t.Errorf("decodeMojibake(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+		if got := Fix(tt.input); got != tt.want {
+			t.Errorf("Fix(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFixPrefix(t *testing.T) {
+	tests := []struct {
+		input    string
+		fixed    string
+		consumed int
+	}{
+		{"cafÃ© ol\xc3", "café", len("cafÃ©")},
+		{"cafÃ© olÃ", "café", len("cafÃ©")},
+		{"one two\r\nthree", "one two", len("one two")},
+		{"caf\xc3", "", 0},
+		{"", "", 0},
+	}
+	for _, tt := range tests {
+		fixed, consumed := FixPrefix(tt.input, DefaultOptions())
+		if fixed != tt.fixed || consumed != tt.consumed {
+			t.Errorf("FixPrefix(%q) = %q, %d, want %q, %d", tt.input, fixed, consumed, tt.fixed, tt.consumed)
+		}
+	}
+
+	// Feeding the unconsumed tail back in once it is complete fixes it too.
+	input := "cafÃ© ol\xc3"
+	fixed, consumed := FixPrefix(input, DefaultOptions())
+	rest := input[consumed:] + "\xa9"
+	if got := fixed + FixWithOptions(rest, DefaultOptions()); got != "café olé" {
+		t.Errorf("FixPrefix then FixWithOptions = %q, want %q", got, "café olé")
+	}
+}
+
+func TestFixReaderWriter(t *testing.T) {
+	var csv strings.Builder
+	csv.WriteString("id,city,note\r\n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&csv, "%d,SÃ£o Paulo,cafÃ© &amp; crÃªpes\r\n", i)
+	}
+	input := csv.String()
+	want := Fix(input)
+
+	var got bytes.Buffer
+	if _, err := io.Copy(&got, FixReader(iotest.HalfReader(strings.NewReader(input)), DefaultOptions())); err != nil {
+		t.Fatalf("io.Copy(FixReader): %v", err)
+	}
+	if got.String() != want {
+		t.Errorf("FixReader output differs from Fix (got %d bytes, want %d)", got.Len(), len(want))
+	}
+
+	got.Reset()
+	w := NewFixWriter(&got, DefaultOptions())
+	if _, err := io.Copy(w, iotest.OneByteReader(strings.NewReader(input[:4096]))); err != nil {
+		t.Fatalf("io.Copy(NewFixWriter): %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if want := Fix(input[:4096]); got.String() != want {
+		t.Errorf("NewFixWriter = %q, want %q", got.String(), want)
+	}
+}
+
+func TestCharsetPreference(t *testing.T) {
+	// U+0080 is byte 0x80 read as Latin-1. Windows-1252 reads 0x80 as "€",
+	// so only a Latin-1 round-trip can turn "Ã\u0080" back into "À".
+	input := "Ã\u0080 la carte"
+	tests := []struct {
+		prefs []string
+		want  string
+	}{
+		{nil, "À la carte"},
+		{[]string{"iso-8859-1"}, "À la carte"},
+		{[]string{"windows-1252", "iso-8859-1"}, "À la carte"},
+		{[]string{"windows-1252"}, "Ã la carte"},
+		{[]string{"koi8-r"}, "À la carte"},
+	}
+	for _, tt := range tests {
+		opts := DefaultOptions()
+		opts.CharsetPreference = tt.prefs
+		if got := FixWithOptions(input, opts); got != tt.want {
+			t.Errorf("FixWithOptions(%q, CharsetPreference %q) = %q, want %q", input, tt.prefs, got, tt.want)
+		}
+	}
+
+	// "â€™" is only valid as Windows-1252 mojibake.
+	opts := DefaultOptions()
+	opts.CharsetPreference = []string{"iso-8859-1"}
+	if got := decodeMojibake("itâ€™s", opts); got != "itâ€™s" {
+		t.Errorf("decodeMojibake(%q, latin-1 only) = %q, want unchanged", "itâ€™s", got)
 	}
 }
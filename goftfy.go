@@ -17,12 +17,21 @@
 package goftfy
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"html"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 
+	"golang.org/x/text/cases"
 	"golang.org/x/text/unicode/norm"
 )
 
@@ -30,117 +39,686 @@ import (
 type Options struct {
 	// FixEncoding fixes mojibake (UTF-8 text misread as Latin-1, etc.)
 	FixEncoding bool
+	// SourceCharset names the charset UTF-8 text was mistakenly decoded as,
+	// skipping mojibake detection: "utf-16le" or "utf-16be". Empty means detect
+	SourceCharset string
+	// SourceEncoding names the true encoding of the original bytes, such as
+	// "utf-8", "windows-1252", "koi8-r" or "iso-8859-5", when it is known.
+	// The text is taken to be those bytes misread as Latin-1 or
+	// Windows-1252 and is decoded with that encoding, skipping detection.
+	// Empty, or a name the WHATWG encoding standard does not know, means detect
+	SourceEncoding string
+	// CharsetPreference lists the charsets mojibake may be reversed from, in
+	// order of preference for breaking ties: "iso-8859-1", "windows-1252",
+	// "windows-1251" (Cyrillic), "iso-8859-7" or "windows-1253" (Greek).
+	// Unknown names are ignored; empty means all five, in that order
+	CharsetPreference []string
+	// FixUTF7 decodes UTF-7 shift sequences such as "+AOk-" (é) left in
+	// legacy mail text
+	FixUTF7 bool
+	// FixPercentEncoding decodes runs of %XX escapes that spell non-ASCII
+	// UTF-8, such as mojibake that was then percent-encoded
+	// ("caf%C3%83%C2%A9"), before the encoding stage repairs them
+	FixPercentEncoding bool
+	// MaxDecodePasses caps how many layers of mojibake the encoding stage
+	// peels off text that was misread more than once. Zero means 4
+	MaxDecodePasses int
 	// FixHTMLEntities decodes HTML entities like &amp; &lt; &#8217; etc.
 	FixHTMLEntities bool
+	// StrictAmpersand only decodes well-formed entities that end in a semicolon
+	// (&name; &#num; &#xhex;), so text like "&copy2024" stays literal
+	StrictAmpersand bool
+	// HTMLEntityMode picks how strictly entities are recognized; HTMLStrict
+	// leaves anything that is not a complete known entity untouched
+	HTMLEntityMode HTMLEntityMode
+	// CaseInsensitiveEntities decodes named entities with nonstandard casing
+	// ("&NBSP;"), falling back to the lowercase name when the exact name is unknown
+	CaseInsensitiveEntities bool
+	// MaxEntityExpansions caps how many entities are decoded per call; the rest
+	// are left as-is. Zero means no limit
+	MaxEntityExpansions int
 	// FixLineBreaks normalizes line endings to \n
 	FixLineBreaks bool
+	// LineEndingStyle is the terminator FixLineBreaks normalizes to: "lf",
+	// "crlf" or "cr". Empty or unknown means "lf"
+	LineEndingStyle string
 	// FixSurrogates removes unpaired UTF-16 surrogates
 	FixSurrogates bool
 	// FixControlChars removes or replaces C0/C1 control characters
 	FixControlChars bool
+	// ControlCharReplacement is written in place of each control character
+	// FixControlChars removes, such as " " or "␊" to keep columns aligned
+	// and words apart; empty means delete
+	ControlCharReplacement string
 	// FixCurlyQuotes optionally straightens curly quotes to ASCII
 	FixCurlyQuotes bool
+	// QuoteStyle picks which quotes FixCurlyQuotes straightens
+	QuoteStyle QuoteStyle
+	// SmartenQuotes turns ASCII ' and " into curly quotes, choosing opening or
+	// closing forms from context; ignored when FixCurlyQuotes is set
+	SmartenQuotes bool
+	// NormalizeEllipsis rewrites every ellipsis form ("…", "...", ". . .") to EllipsisStyle
+	NormalizeEllipsis bool
+	// EllipsisStyle picks the canonical ellipsis for NormalizeEllipsis
+	EllipsisStyle EllipsisStyle
+	// PunctuationMap holds extra punctuation replacements (old → new) applied
+	// alongside NormalizeEllipsis; longer keys win over shorter ones
+	PunctuationMap map[string]string
+	// CanonicalOrdering sorts runs of combining marks by canonical combining
+	// class, the reordering step of normalization without any composition
+	CanonicalOrdering bool
 	// NormalizationForm applies Unicode normalization (NFC, NFD, NFKC, NFKD) or "" for none
 	NormalizationForm string
 	// RemoveTerminalEscapes strips ANSI escape sequences
 	RemoveTerminalEscapes bool
+	// RemoveZeroWidth strips invisible zero-width characters (ZWSP, word joiner,
+	// U+FEFF, stray combining grapheme joiners); ZWJ and ZWNJ are kept
+	RemoveZeroWidth bool
+	// StripBOM removes a byte-order mark (U+FEFF) from the start of the text.
+	// Interior U+FEFF is left alone; RemoveZeroWidth strips it
+	StripBOM bool
+	// CollapseWhitespace collapses runs of horizontal whitespace into a single space
+	CollapseWhitespace bool
+	// TrimTrailingSpace strips whitespace from the end of every line
+	TrimTrailingSpace bool
+	// EnsureFinalNewline ends non-empty text with exactly one line
+	// terminator, the one LineEndingStyle names, removing any extra ones.
+	// Like TrimTrailingSpace it works whether or not FixLineBreaks is set.
+	// It applies once to the whole document, after the pipeline: helpers that
+	// fix pieces of one (FixJSON, FixEnv, FixPrefix, ...) ignore it, and a
+	// StreamFixer applies it at Flush
+	EnsureFinalNewline bool
+	// NumericDashes turns minus signs and dashes directly before a digit into ASCII '-'
+	NumericDashes bool
+	// TidyPunctuationSpacing puts a single space on both sides of a spaced em
+	// dash and a single space after sentence-ending punctuation
+	TidyPunctuationSpacing bool
+	// FoldRomanNumerals maps Roman numeral characters (U+2160–U+217F) to ASCII letters
+	FoldRomanNumerals bool
+	// TabIsDelimiter guarantees that no stage removes or alters tab characters,
+	// for TSV-style data where tabs separate fields
+	TabIsDelimiter bool
+	// FixKeys makes FixJSON fix object keys as well as string values
+	FixKeys bool
+	// Allowlist, when set, overrides every stripping decision: runes for which
+	// it returns true are never removed or replaced by control-character,
+	// zero-width, whitespace, punctuation-spacing or terminal-escape handling
+	Allowlist func(rune) bool
 }
 
+// EllipsisStyle selects the canonical form NormalizeEllipsis produces.
+type EllipsisStyle int
+
+const (
+	// EllipsisChar is the single character U+2026 "…".
+	EllipsisChar EllipsisStyle = iota
+	// EllipsisDots is three ASCII full stops "...".
+	EllipsisDots
+)
+
+// HTMLEntityMode selects how the HTML entity stage recognizes entities.
+type HTMLEntityMode int
+
+const (
+	// HTMLLenient decodes whatever html.UnescapeString does, including a
+	// known entity name at the start of a longer word, so "&notit;" becomes
+	// "¬it;".
+	HTMLLenient HTMLEntityMode = iota
+	// HTMLStrict decodes only semicolon-terminated entities whose whole name
+	// is in the HTML table, and numeric references to valid characters.
+	// Every other ampersand is left alone.
+	HTMLStrict
+)
+
+// QuoteStyle selects which quotation marks FixCurlyQuotes straightens.
+type QuoteStyle int
+
+const (
+	// QuoteASCII straightens every curly quote and guillemet to ASCII.
+	QuoteASCII QuoteStyle = iota
+	// QuotePreserveGuillemets straightens curly quotes, including low-9
+	// forms such as „ and ‚, but keeps « » and ‹ ›.
+	QuotePreserveGuillemets
+	// QuoteCurlyToStraight straightens only the English quotes ‘ ’ “ ”,
+	// keeping low-9 quotes and guillemets.
+	QuoteCurlyToStraight
+)
+
 // DefaultOptions returns the recommended default options (mirrors ftfy defaults).
 func DefaultOptions() Options {
 	return Options{
-		FixEncoding:           true,
-		FixHTMLEntities:       true,
-		FixLineBreaks:         true,
-		FixSurrogates:         true,
-		FixControlChars:       true,
-		FixCurlyQuotes:        false,
-		NormalizationForm:     "NFC",
-		RemoveTerminalEscapes: false,
+		FixEncoding:             true,
+		SourceCharset:           "",
+		SourceEncoding:          "",
+		CharsetPreference:       nil,
+		FixUTF7:                 false,
+		FixPercentEncoding:      false,
+		MaxDecodePasses:         0,
+		FixHTMLEntities:         true,
+		StrictAmpersand:         false,
+		HTMLEntityMode:          HTMLLenient,
+		CaseInsensitiveEntities: false,
+		MaxEntityExpansions:     0,
+		FixLineBreaks:           true,
+		LineEndingStyle:         "",
+		FixSurrogates:           true,
+		FixControlChars:         true,
+		ControlCharReplacement:  "",
+		FixCurlyQuotes:          false,
+		QuoteStyle:              QuoteASCII,
+		SmartenQuotes:           false,
+		NormalizeEllipsis:       false,
+		EllipsisStyle:           EllipsisChar,
+		PunctuationMap:          nil,
+		CanonicalOrdering:       false,
+		NormalizationForm:       "NFC",
+		RemoveTerminalEscapes:   false,
+		RemoveZeroWidth:         false,
+		StripBOM:                true,
+		CollapseWhitespace:      false,
+		TrimTrailingSpace:       false,
+		EnsureFinalNewline:      false,
+		NumericDashes:           false,
+		TidyPunctuationSpacing:  false,
+		FoldRomanNumerals:       false,
+		TabIsDelimiter:          false,
+		FixKeys:                 false,
+		Allowlist:               nil,
 	}
 }
 
 // Fix applies all default fixes to the input string and returns the corrected text.
+// Fixing the result again changes nothing, Fix(Fix(text)) == Fix(text),
+// within the limits described at FixWithOptions.
 func Fix(text string) string {
 	return FixWithOptions(text, DefaultOptions())
 }
 
+// Clean applies an opinionated "maximum cleanup", for callers who want the
+// cleanest reasonable output rather than the conservative Fix. It is
+// DefaultOptions plus FixCurlyQuotes, CollapseWhitespace and
+// RemoveZeroWidth, so it: repairs mojibake and surrogates, strips a leading
+// BOM, decodes HTML entities, normalizes line breaks to "\n", strips control
+// characters and zero-width characters (keeping ZWJ), collapses runs of
+// horizontal whitespace, straightens curly quotes and guillemets to ASCII,
+// and normalizes to NFC.
+func Clean(text string) string {
+	opts := DefaultOptions()
+	opts.FixCurlyQuotes = true
+	opts.CollapseWhitespace = true
+	opts.RemoveZeroWidth = true
+	return FixWithOptions(text, opts)
+}
+
 // FixWithOptions applies only the selected fixes from opts.
+//
+// A stage can leave work for an earlier one: decoding "&Atilde;&copy;"
+// yields the mojibake "Ã©", and "&amp;amp;" decodes to "&amp;". So that
+// fixing already-fixed text changes nothing, the pipeline is run again
+// until the text stops changing, up to maxFixRounds times; clean text takes
+// a single run. The result is idempotent unless damage is nested deeper than
+// that, such as an entity escaped five times over. MaxEntityExpansions caps
+// entities per run, so with a cap set the pipeline runs only once.
 func FixWithOptions(text string, opts Options) string {
-	if opts.RemoveTerminalEscapes {
-		text = removeTerminalEscapes(text)
-	}
-	if opts.FixSurrogates {
-		text = fixSurrogates(text)
+	if statsEnabled.Load() {
+		return fixCounted(text, opts)
 	}
-	if opts.FixEncoding {
-		text = fixEncoding(text)
+	return fixRounds(text, opts, opts.Pipeline())
+}
+
+// maxFixRounds bounds how many times FixWithOptions runs the pipeline. Each
+// extra run only happens when the previous one changed the text; real input
+// settles within two or three.
+const maxFixRounds = 4
+
+// fixRoundLimit returns how many times the pipeline may run for opts:
+// maxFixRounds, or once if opts caps entity expansions.
+func fixRoundLimit(opts Options) int {
+	if opts.MaxEntityExpansions > 0 {
+		return 1
 	}
-	if opts.FixHTMLEntities {
-		text = fixHTMLEntities(text)
+	return maxFixRounds
+}
+
+// fixRounds runs stages over text until it stops changing, at most
+// fixRoundLimit(opts) times.
+func fixRounds(text string, opts Options, stages []Stage) string {
+	for i := 0; i < fixRoundLimit(opts); i++ {
+		fixed := ApplyPipeline(text, stages)
+		if fixed == text {
+			break
+		}
+		text = fixed
 	}
-	if opts.FixLineBreaks {
-		text = fixLineBreaks(text)
+	for _, st := range opts.documentStages() {
+		text = st.Fn(text)
 	}
-	if opts.FixControlChars {
-		text = fixControlChars(text)
+	return text
+}
+
+// fixRoundsWith is fixRounds for the entry points that report on each
+// step: every stage is run by calling apply, which returns the stage's
+// result for text and may time or record it along the way.
+func fixRoundsWith(text string, opts Options, stages []Stage, apply func(st Stage, text string) string) string {
+	for i := 0; i < fixRoundLimit(opts); i++ {
+		start := text
+		for _, st := range stages {
+			text = apply(st, text)
+		}
+		if text == start {
+			break
+		}
 	}
-	if opts.FixCurlyQuotes {
-		text = fixCurlyQuotes(text)
+	for _, st := range opts.documentStages() {
+		text = apply(st, text)
 	}
-	if opts.NormalizationForm != "" {
-		text = normalize(text, opts.NormalizationForm)
+	return text
+}
+
+// ApplyPipeline runs text through stages in order, once, and returns the
+// result. FixWithOptions repeats ApplyPipeline(text, opts.Pipeline()) until
+// the text stops changing, then applies EnsureFinalNewline.
+func ApplyPipeline(text string, stages []Stage) string {
+	for _, st := range stages {
+		text = st.Fn(text)
 	}
 	return text
 }
 
-// Explain returns a human-readable description of what fixes were applied.
-//
-// Note: Explain() does not accept Options, so it infers applied stages by
-// replaying the default pipeline in order and recording which stages changed
-// the text. If the provided "fixed" string does not match what the default
-// pipeline would produce, the explanation is marked as inferred.
-func Explain(original, fixed string) string {
-	if original == fixed {
-		return "No changes needed."
+// FixContext is like FixWithOptions but checks ctx between stages, returning
+// ctx.Err() as soon as the context is cancelled or its deadline passes. Use
+// it to bound the time spent on untrusted, possibly adversarial input.
+func FixContext(ctx context.Context, text string, opts Options) (string, error) {
+	var err error
+	text = fixRoundsWith(text, opts, opts.Pipeline(), func(st Stage, text string) string {
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			return text
+		}
+		return st.Fn(text)
+	})
+	if err == nil {
+		err = ctx.Err()
 	}
+	if err != nil {
+		return "", err
+	}
+	return text, nil
+}
 
-	// Infer changes in the same order as FixWithOptions uses.
-	text := original
-	var notes []string
+// FixBounded applies FixWithOptions unless the fix would remove more than
+// maxRemovedRunes runes (net). In that case the original text is returned
+// with false: for some fields heavy loss is more suspicious than the
+// corruption it would repair.
+func FixBounded(text string, maxRemovedRunes int, opts Options) (string, bool) {
+	fixed := FixWithOptions(text, opts)
+	if utf8.RuneCountInString(text)-utf8.RuneCountInString(fixed) > maxRemovedRunes {
+		return text, false
+	}
+	return fixed, true
+}
 
-	stage := func(name string, fn func(string) string) {
-		newText := fn(text)
-		if newText != text {
-			notes = append(notes, name)
-			text = newText
+// FixCapped applies FixWithOptions and then truncates the result to at most
+// maxBytes bytes, for fixed-size database columns. Truncation happens at a
+// grapheme boundary (see FixAligned), so neither a rune nor a combining
+// sequence, emoji sequence or flag is split. It reports whether the result
+// was truncated.
+func FixCapped(text string, maxBytes int, opts Options) (string, bool) {
+	fixed := FixWithOptions(text, opts)
+	if len(fixed) <= maxBytes {
+		return fixed, false
+	}
+	end := 0
+	for end < len(fixed) {
+		n := graphemeLen(fixed[end:])
+		if end+n > maxBytes {
+			break
 		}
+		end += n
 	}
+	return fixed[:end], true
+}
 
-	opts := DefaultOptions()
+// FixIfContains runs FixWithOptions only if text contains at least one of
+// the trigger runes (for example 'Ã', '&' and '\r'), and otherwise returns
+// text unchanged without running any stage. Callers who know which
+// characters signal trouble in their corpus can use it as a cheap pre-filter.
+func FixIfContains(text string, triggers []rune, opts Options) string {
+	if !strings.ContainsFunc(text, func(r rune) bool { return slices.Contains(triggers, r) }) {
+		return text
+	}
+	return FixWithOptions(text, opts)
+}
+
+// optionToggles lists the Options fields that enable a pipeline stage, in
+// pipeline order. Modifier fields such as StrictAmpersand or TabIsDelimiter
+// only change how an enabled stage behaves and are not listed. Neither is
+// EnsureFinalNewline, which changes any text lacking a final newline and so
+// says nothing about its problems.
+var optionToggles = []struct {
+	name   string
+	enable func(*Options)
+}{
+	{"RemoveTerminalEscapes", func(o *Options) { o.RemoveTerminalEscapes = true }},
+	{"FixSurrogates", func(o *Options) { o.FixSurrogates = true }},
+	{"FixUTF7", func(o *Options) { o.FixUTF7 = true }},
+	{"FixPercentEncoding", func(o *Options) { o.FixPercentEncoding = true }},
+	{"FixEncoding", func(o *Options) { o.FixEncoding = true }},
+	{"StripBOM", func(o *Options) { o.StripBOM = true }},
+	{"FixHTMLEntities", func(o *Options) { o.FixHTMLEntities = true }},
+	{"FixLineBreaks", func(o *Options) { o.FixLineBreaks = true }},
+	{"FixControlChars", func(o *Options) { o.FixControlChars = true }},
+	{"RemoveZeroWidth", func(o *Options) { o.RemoveZeroWidth = true }},
+	{"CollapseWhitespace", func(o *Options) { o.CollapseWhitespace = true }},
+	{"TrimTrailingSpace", func(o *Options) { o.TrimTrailingSpace = true }},
+	{"FixCurlyQuotes", func(o *Options) { o.FixCurlyQuotes = true }},
+	{"SmartenQuotes", func(o *Options) { o.SmartenQuotes = true }},
+	{"NormalizeEllipsis", func(o *Options) { o.NormalizeEllipsis = true }},
+	{"NumericDashes", func(o *Options) { o.NumericDashes = true }},
+	{"TidyPunctuationSpacing", func(o *Options) { o.TidyPunctuationSpacing = true }},
+	{"FoldRomanNumerals", func(o *Options) { o.FoldRomanNumerals = true }},
+	{"CanonicalOrdering", func(o *Options) { o.CanonicalOrdering = true }},
+	{"NormalizationForm", func(o *Options) { o.NormalizationForm = "NFC" }},
+}
+
+// RelevantOptions returns the names of the Options fields whose stage would
+// change text if enabled on its own, in pipeline order. Plain ASCII text
+// yields an empty result. NormalizationForm is checked with "NFC". It helps
+// pick the minimal configuration for a corpus.
+func RelevantOptions(text string) []string {
+	var relevant []string
+	for _, t := range optionToggles {
+		var opts Options
+		t.enable(&opts)
+		if FixWithOptions(text, opts) != text {
+			relevant = append(relevant, t.name)
+		}
+	}
+	return relevant
+}
+
+// FixProfiled is like FixWithOptions but also reports how long each enabled
+// stage took, keyed by stage name ("encoding", "html_entities", ...), summed
+// over all rounds. Timing is only taken here, so FixWithOptions carries no
+// profiling overhead.
+func FixProfiled(text string, opts Options) (string, map[string]time.Duration) {
+	stages := opts.Pipeline()
+	timings := make(map[string]time.Duration, len(stages))
+	text = fixRoundsWith(text, opts, stages, func(st Stage, text string) string {
+		start := time.Now()
+		text = st.Fn(text)
+		timings[st.Name] += time.Since(start)
+		return text
+	})
+	return text, timings
+}
+
+// allowed reports whether opts.Allowlist protects r from being stripped.
+func (opts Options) allowed(r rune) bool {
+	return opts.Allowlist != nil && opts.Allowlist(r)
+}
+
+// keepSpace reports whether the whitespace stages must leave r alone: a tab
+// when TabIsDelimiter is set, or anything the Allowlist protects.
+func (opts Options) keepSpace(r rune) bool {
+	return (r == '\t' && opts.TabIsDelimiter) || opts.allowed(r)
+}
+
+// stagePriority ranks stages by value for FixBudget, highest first: repairing
+// mojibake and entities matters most, cosmetic folds least.
+var stagePriority = []string{
+	"encoding", "html_entities", "utf7", "percent_encoding", "surrogates", "control_chars",
+	"bom", "line_breaks", "normalization", "terminal_escapes", "zero_width",
+	"whitespace", "trailing_space", "final_newline", "canonical_ordering", "punctuation", "curly_quotes", "smart_quotes",
+	"numeric_dashes", "punctuation_spacing", "roman_numerals",
+}
+
+// FixBudget is like FixWithOptions but applies at most maxStages of the
+// stages opts enables, picking them by priority (encoding first, then HTML
+// entities, ...). The chosen stages still run in pipeline order so they
+// interact as usual. It bounds the work done in latency-sensitive paths at
+// the cost of completeness; maxStages <= 0 returns text unchanged.
+func FixBudget(text string, opts Options, maxStages int) string {
+	stages := opts.Pipeline()
+	enabled := append(opts.documentStages(), stages...)
+	chosen := make(map[string]bool, maxStages)
+	for _, name := range stagePriority {
+		if len(chosen) >= maxStages {
+			break
+		}
+		for _, st := range enabled {
+			if st.Name == name {
+				chosen[name] = true
+			}
+		}
+	}
+	opts.EnsureFinalNewline = opts.EnsureFinalNewline && chosen["final_newline"]
+	var selected []Stage
+	for _, st := range stages {
+		if chosen[st.Name] {
+			selected = append(selected, st)
+		}
+	}
+	return fixRounds(text, opts, selected)
+}
+
+// Stage is one named step of the fixing pipeline. Name identifies the
+// built-in stages ("encoding", "html_entities", ...) in reports such as
+// FixStageMask and FixProfiled; custom stages may use any name.
+type Stage struct {
+	Name string
+	Fn   func(string) string
+}
+
+// Pipeline returns the stages opts enables, in the order FixWithOptions runs
+// them. The slice is freshly built on every call, so callers can drop,
+// reorder or insert stages (a custom sanitizer, say) and run the result with
+// ApplyPipeline while still reusing the standard steps. EnsureFinalNewline
+// is not among them; see documentStages.
+func (opts Options) Pipeline() []Stage {
+	var stages []Stage
+	add := func(name string, fn func(string) string) {
+		stages = append(stages, Stage{Name: name, Fn: fn})
+	}
 	if opts.RemoveTerminalEscapes {
-		stage("removed terminal escapes", removeTerminalEscapes)
+		add("terminal_escapes", func(s string) string { return removeTerminalEscapes(s, opts.allowed) })
 	}
 	if opts.FixSurrogates {
-		stage("fixed surrogates", fixSurrogates)
+		add("surrogates", fixSurrogates)
+	}
+	if opts.FixUTF7 {
+		add("utf7", decodeUTF7)
+	}
+	if opts.FixPercentEncoding {
+		add("percent_encoding", decodePercentEncoding)
 	}
 	if opts.FixEncoding {
-		stage("fixed mojibake encoding", fixEncoding)
+		add("encoding", func(s string) string { return fixEncoding(s, opts) })
+	}
+	if opts.StripBOM && !opts.allowed('\uFEFF') {
+		add("bom", stripBOM)
 	}
 	if opts.FixHTMLEntities {
-		stage("decoded HTML entities", fixHTMLEntities)
+		add("html_entities", func(s string) string { return fixHTMLEntities(s, opts) })
 	}
 	if opts.FixLineBreaks {
-		stage("normalized line breaks", fixLineBreaks)
+		eol := lineEnding(opts.LineEndingStyle)
+		add("line_breaks", func(s string) string { return fixLineBreaks(s, eol) })
 	}
 	if opts.FixControlChars {
-		stage("removed control characters", fixControlChars)
+		add("control_chars", func(s string) string { return fixControlChars(s, opts.allowed, opts.ControlCharReplacement) })
+	}
+	if opts.RemoveZeroWidth {
+		add("zero_width", func(s string) string { return removeZeroWidth(s, opts.allowed) })
+	}
+	if opts.CollapseWhitespace {
+		add("whitespace", func(s string) string { return collapseWhitespace(s, opts.keepSpace) })
+	}
+	if opts.TrimTrailingSpace {
+		add("trailing_space", func(s string) string { return trimTrailingSpace(s, opts.keepSpace) })
 	}
 	if opts.FixCurlyQuotes {
-		stage("straightened curly quotes", fixCurlyQuotes)
+		style := opts.QuoteStyle
+		add("curly_quotes", func(s string) string { return fixCurlyQuotes(s, style) })
+	} else if opts.SmartenQuotes {
+		add("smart_quotes", smartenQuotes)
+	}
+	if opts.NormalizeEllipsis || len(opts.PunctuationMap) > 0 {
+		r := punctuationReplacer(opts)
+		add("punctuation", r.Replace)
+	}
+	if opts.NumericDashes {
+		add("numeric_dashes", fixNumericDashes)
+	}
+	if opts.TidyPunctuationSpacing {
+		add("punctuation_spacing", func(s string) string { return tidyPunctuationSpacing(s, opts.keepSpace) })
+	}
+	if opts.FoldRomanNumerals {
+		add("roman_numerals", foldRomanNumerals)
+	}
+	if opts.CanonicalOrdering {
+		add("canonical_ordering", canonicalOrder)
 	}
 	if opts.NormalizationForm != "" {
-		stage("normalized unicode", func(s string) string { return normalize(s, opts.NormalizationForm) })
+		form := opts.NormalizationForm
+		add("normalization", func(s string) string { return normalize(s, form) })
+	}
+	return stages
+}
+
+// documentStages returns the stages that apply once to a whole document,
+// after the pipeline has settled, rather than on every run: for now only
+// "final_newline". Keeping it out of Pipeline means the helpers that fix
+// fragments of a document never end each fragment with a newline.
+func (opts Options) documentStages() []Stage {
+	if !opts.EnsureFinalNewline {
+		return nil
+	}
+	eol := lineEnding(opts.LineEndingStyle)
+	return []Stage{{Name: "final_newline", Fn: func(s string) string { return ensureFinalNewline(s, eol) }}}
+}
+
+// fragment returns opts for fixing a piece of a larger document, such as a
+// JSON string or one word of a stream, where EnsureFinalNewline does not
+// apply.
+func (opts Options) fragment() Options {
+	opts.EnsureFinalNewline = false
+	return opts
+}
+
+// fixTracked fixes text as FixWithOptions does and also returns the names
+// of the stages that changed it, in order of their first change.
+func fixTracked(text string, opts Options) (string, []string) {
+	var changed []string
+	text = fixRoundsWith(text, opts, opts.Pipeline(), func(st Stage, text string) string {
+		newText := st.Fn(text)
+		if newText != text && !slices.Contains(changed, st.Name) {
+			changed = append(changed, st.Name)
+		}
+		return newText
+	})
+	return text, changed
+}
+
+// FixStageMask is like FixWithOptions but also reports, for every stage opts
+// enables, whether that stage changed the text in any round. Stages that are
+// not enabled are absent from the map. It is a structured, per-stage
+// counterpart to Explain for telemetry, and uses the options actually given.
+func FixStageMask(text string, opts Options) (string, map[string]bool) {
+	stages := opts.Pipeline()
+	mask := make(map[string]bool, len(stages))
+	for _, st := range append(opts.documentStages(), stages...) {
+		mask[st.Name] = false
+	}
+	text = fixRoundsWith(text, opts, stages, func(st Stage, text string) string {
+		newText := st.Fn(text)
+		mask[st.Name] = mask[st.Name] || newText != text
+		return newText
+	})
+	return text, mask
+}
+
+// stageNotes describes what each pipeline stage did, for Explain.
+var stageNotes = map[string]string{
+	"terminal_escapes":    "removed terminal escapes",
+	"surrogates":          "fixed surrogates",
+	"utf7":                "decoded UTF-7",
+	"percent_encoding":    "decoded percent-encoding",
+	"encoding":            "fixed mojibake encoding",
+	"bom":                 "removed byte-order mark",
+	"html_entities":       "decoded HTML entities",
+	"line_breaks":         "normalized line breaks",
+	"control_chars":       "removed control characters",
+	"zero_width":          "removed zero-width characters",
+	"whitespace":          "collapsed whitespace",
+	"trailing_space":      "trimmed trailing whitespace",
+	"final_newline":       "normalized final newline",
+	"curly_quotes":        "straightened curly quotes",
+	"smart_quotes":        "curled straight quotes",
+	"punctuation":         "normalized punctuation",
+	"numeric_dashes":      "normalized numeric dashes",
+	"punctuation_spacing": "tidied punctuation spacing",
+	"roman_numerals":      "folded roman numerals",
+	"canonical_ordering":  "reordered combining marks",
+	"normalization":       "normalized unicode",
+}
+
+// Change is one distinct edit a pipeline stage made: the stage replaced
+// Before with After Count times. Like FixAsScript, edits are widened to the
+// whitespace-delimited words they touch, so Before and After read naturally.
+type Change struct {
+	Stage  string
+	Before string
+	After  string
+	Count  int
+}
+
+// ExplainWithOptions fixes original with opts, exactly as FixWithOptions
+// does, and also returns the edits each stage made, in the order the stages
+// ran and, within a stage, in order of first occurrence. Edits from a later
+// round follow those of the first. Identical edits by the same stage are
+// reported once with a Count.
+func ExplainWithOptions(original string, opts Options) (fixed string, changes []Change) {
+	index := make(map[[3]string]int)
+	fixed = fixRoundsWith(original, opts, opts.Pipeline(), func(st Stage, text string) string {
+		newText := st.Fn(text)
+		if newText == text {
+			return text
+		}
+		a, b := []rune(text), []rune(newText)
+		for _, h := range wordHunks(a, b) {
+			key := [3]string{st.Name, string(a[h.aStart:h.aEnd]), string(b[h.bStart:h.bEnd])}
+			if i, ok := index[key]; ok {
+				changes[i].Count++
+				continue
+			}
+			index[key] = len(changes)
+			changes = append(changes, Change{Stage: st.Name, Before: key[1], After: key[2], Count: 1})
+		}
+		return newText
+	})
+	return fixed, changes
+}
+
+// Explain returns a human-readable description of what fixes were applied.
+//
+// Note: Explain() does not accept Options, so it infers applied stages by
+// replaying the default pipeline with ExplainWithOptions and recording which
+// stages changed the text. If the provided "fixed" string does not match what
+// the default pipeline would produce, the explanation is marked as inferred.
+func Explain(original, fixed string) string {
+	if original == fixed {
+		return "No changes needed."
+	}
+
+	text, changes := ExplainWithOptions(original, DefaultOptions())
+	var notes []string
+	for i, c := range changes {
+		if i == 0 || c.Stage != changes[i-1].Stage {
+			notes = append(notes, stageNotes[c.Stage])
+		}
 	}
 
 	if len(notes) == 0 {
@@ -159,11 +737,59 @@ func IsValid(text string) bool {
 	return Fix(text) == text
 }
 
-// FixLines fixes each line of a multi-line string independently.
+// FixNFC applies the default fixes, guaranteeing NFC output, and reports
+// whether the input was already in NFC. Storage layers that require NFC can
+// use the flag to skip rewriting records that were already normalized.
+func FixNFC(text string) (string, bool) {
+	opts := DefaultOptions()
+	opts.NormalizationForm = "NFC"
+	return FixWithOptions(text, opts), norm.NFC.IsNormalString(text)
+}
+
+// ErrNotClean is wrapped by the error MustBeClean returns for text that
+// needs fixing.
+var ErrNotClean = errors.New("goftfy: text needs fixing")
+
+// MustBeClean returns nil when text is valid (see IsValid) and otherwise an
+// error wrapping ErrNotClean that describes the first problem found and its
+// byte offset. It is meant for validation gates that reject unclean input
+// from upstream producers.
+func MustBeClean(text string) error {
+	fixed := Fix(text)
+	if fixed == text {
+		return nil
+	}
+	rs := []rune(text)
+	i := 0
+	// Ranging over text gives real byte offsets, also past invalid bytes.
+	for offset, r := range text {
+		if info := analyzeRune(rs, i); info.IsProblematic {
+			return fmt.Errorf("%w: %s %U %q at byte offset %d", ErrNotClean, info.Category, r, r, offset)
+		}
+		i++
+	}
+	// Nothing is suspicious on its own (e.g. an HTML entity or a CRLF), so
+	// point at the first place where the fixed text differs, widened to the
+	// start of the entity it falls in: "&amp;" changes at "amp;".
+	h := diffHunks(rs, []rune(fixed))[0]
+	offsets := runeOffsets(text)
+	start, end := offsets[h.aStart], offsets[h.aEnd]
+	for _, loc := range lenientEntity.FindAllStringIndex(text, -1) {
+		if loc[0] <= start && start < loc[1] {
+			start, end = loc[0], max(end, loc[1])
+			break
+		}
+	}
+	return fmt.Errorf("%w: %q would change at byte offset %d", ErrNotClean, text[start:end], start)
+}
+
+// FixLines fixes each line of a multi-line string independently. A BOM or
+// zero-width space at the start of a line is stripped first, since some
+// exports prepend one to every line rather than only to the file.
 func FixLines(text string) string {
 	lines := strings.Split(text, "\n")
 	for i, line := range lines {
-		lines[i] = Fix(line)
+		lines[i] = Fix(strings.TrimLeft(line, lineStartInvisibles))
 	}
 	return strings.Join(lines, "\n")
 }
@@ -177,6 +803,25 @@ func FixSlice(texts []string) []string {
 	return result
 }
 
+// lineStartInvisibles are stripped from the start of each line by FixLines.
+const lineStartInvisibles = "\uFEFF\u200B\u2060"
+
+// FixSliceWithOriginals fixes every string in a slice like FixSlice and also
+// returns the pre-fix value of each entry that changed, keyed by its index.
+// Unchanged entries are not recorded, so the map stays small for mostly
+// clean input while still allowing every fix to be audited or undone.
+func FixSliceWithOriginals(texts []string) ([]string, map[int]string) {
+	fixed := make([]string, len(texts))
+	originals := make(map[int]string)
+	for i, t := range texts {
+		fixed[i] = Fix(t)
+		if fixed[i] != t {
+			originals[i] = t
+		}
+	}
+	return fixed, originals
+}
+
 // FixMap fixes every value in a map[string]string.
 func FixMap(m map[string]string) map[string]string {
 	result := make(map[string]string, len(m))
@@ -186,18 +831,35 @@ func FixMap(m map[string]string) map[string]string {
 	return result
 }
 
-// CountProblems returns the number of characters that appear to be encoding artifacts.
+// FixMapReport is a dry run of FixMap: it returns, for each key whose value
+// Fix would change, a Change holding the whole value Before and After,
+// with Count 1 and no Stage. Unchanged keys are omitted and m is not
+// modified, so the report can be reviewed before running FixMap.
+func FixMapReport(m map[string]string) map[string]Change {
+	report := make(map[string]Change)
+	for k, v := range m {
+		if fixed := Fix(v); fixed != v {
+			report[k] = Change{Before: v, After: fixed, Count: 1}
+		}
+	}
+	return report
+}
+
+// CountProblems returns the number of problems Fix corrects in text. The
+// text is aligned with its fixed form and each changed region counts as the
+// number of characters it becomes, and at least one, so "SÃ£o" counts 1 (one
+// mojibake sequence), "&amp;" counts 1, and same-length substitutions such
+// as U+2028 to "\n" are counted too.
 func CountProblems(text string) int {
 	fixed := Fix(text)
 	if text == fixed {
 		return 0
 	}
-	// Approximate: count UTF-8 rune length difference. Never return negative.
-	diff := utf8.RuneCountInString(text) - utf8.RuneCountInString(fixed)
-	if diff < 0 {
-		return 0
+	count := 0
+	for _, h := range diffHunks([]rune(text), []rune(fixed)) {
+		count += max(h.bEnd-h.bStart, 1)
 	}
-	return diff
+	return count
 }
 
 // normalize applies Unicode normalization (NFC, NFD, NFKC, NFKD).
@@ -216,54 +878,318 @@ func normalize(text, form string) string {
 	}
 }
 
+// canonicalOrder stably sorts every run of combining marks by canonical
+// combining class, as the Canonical Ordering Algorithm does. Nothing is
+// decomposed or composed, so the text otherwise keeps its form.
+func canonicalOrder(text string) string {
+	rs := []rune(text)
+	changed := false
+	for i := 0; i < len(rs); {
+		if combiningClass(rs[i]) == 0 {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(rs) && combiningClass(rs[j]) != 0 {
+			j++
+		}
+		run := rs[i:j]
+		if !sort.SliceIsSorted(run, func(a, b int) bool { return combiningClass(run[a]) < combiningClass(run[b]) }) {
+			sort.SliceStable(run, func(a, b int) bool { return combiningClass(run[a]) < combiningClass(run[b]) })
+			changed = true
+		}
+		i = j
+	}
+	if !changed {
+		return text
+	}
+	return string(rs)
+}
+
+func combiningClass(r rune) uint8 {
+	return norm.NFD.PropertiesString(string(r)).CCC()
+}
+
+// RemoveDiacritics strips combining marks from Latin letters, turning
+// "naïve résumé" into "naive resume". Other scripts, punctuation and
+// letters without a canonical decomposition (such as "ø" or "ł") are left
+// alone. The result is NFC-normalized.
+func RemoveDiacritics(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	base := rune(-1)
+	for _, r := range norm.NFD.String(text) {
+		if unicode.Is(unicode.Mn, r) {
+			if base >= 0 && unicode.Is(unicode.Latin, base) {
+				continue
+			}
+		} else {
+			base = r
+		}
+		b.WriteRune(r)
+	}
+	return norm.NFC.String(b.String())
+}
+
+// FoldForSearch prepares text for search indexing: NFKC normalization folds
+// compatibility forms such as fullwidth "ＡＢＣ１２３" and the "ﬁ" ligature to
+// their plain equivalents, Unicode case folding makes matching
+// case-insensitive ("Straße" and "STRASSE" both become "strasse"), and every
+// run of whitespace, line breaks included, becomes a single space with none
+// at either end. Accents are kept; see RemoveDiacritics. The result is for
+// comparison and indexing, not display.
+func FoldForSearch(text string) string {
+	folded := cases.Fold().String(normalize(text, "NFKC"))
+	return strings.Join(strings.Fields(folded), " ")
+}
+
 // ansiEscape matches ANSI terminal escape sequences.
 var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]|\x1b[^[\\]`)
 
-func removeTerminalEscapes(text string) string {
+// removeTerminalEscapes strips ANSI escape sequences, unless ESC itself is
+// allowed, in which case they are kept intact.
+func removeTerminalEscapes(text string, allowed func(rune) bool) string {
+	if allowed('\x1b') {
+		return text
+	}
 	return ansiEscape.ReplaceAllString(text, "")
 }
 
-func fixHTMLEntities(text string) string {
+// strictEntity matches a complete, semicolon-terminated HTML entity.
+var strictEntity = regexp.MustCompile(`&(?:#[0-9]+|#[xX][0-9a-fA-F]+|[A-Za-z][A-Za-z0-9]*);`)
+
+// lenientEntity matches everything html.UnescapeString may decode,
+// including entities without a trailing semicolon.
+var lenientEntity = regexp.MustCompile(`&(?:#[0-9]+|#[xX][0-9a-fA-F]+|[A-Za-z][A-Za-z0-9]*);?`)
+
+// numericEntity matches a numeric character reference and its optional
+// semicolon, capturing the "x" or "X" and digits of a hex reference, the
+// digits of a decimal one, and the semicolon.
+var numericEntity = regexp.MustCompile(`&#(?:([xX])([0-9a-fA-F]+)|([0-9]+))(;?)`)
+
+// repairNumericEntities adds the semicolon missing from numeric references
+// such as "&#8217" and "&#x2019", common in scraped text. The unescaper
+// reads digits greedily, so in "l&#x2019eau" it would take "2019ea" as the
+// code point; a run longer than four digits whose first four name a
+// punctuation mark is cut there instead. Runs that name no valid character
+// either way are left alone.
+func repairNumericEntities(text string) string {
+	if !strings.Contains(text, "&#") {
+		return text
+	}
+	return numericEntity.ReplaceAllStringFunc(text, func(m string) string {
+		sub := numericEntity.FindStringSubmatch(m)
+		if sub[4] == ";" {
+			return m
+		}
+		x, digits, base := sub[1], sub[2], 16
+		if x == "" {
+			digits, base = sub[3], 10
+		}
+		value := func(d string) (rune, bool) {
+			n, err := strconv.ParseInt(d, base, 32)
+			return rune(n), err == nil && n != 0 && utf8.ValidRune(rune(n))
+		}
+		cut := len(digits)
+		if cut > 4 {
+			if r, ok := value(digits[:4]); ok && unicode.IsPunct(r) {
+				cut = 4
+			}
+		}
+		if _, ok := value(digits[:cut]); !ok {
+			return m
+		}
+		return "&#" + x + digits[:cut] + ";" + digits[cut:]
+	})
+}
+
+// namedEntity matches a semicolon-terminated named entity.
+var namedEntity = regexp.MustCompile(`&[A-Za-z][A-Za-z0-9]*;`)
+
+// decodeNamedEntity decodes entity (such as "&amp;") if its whole name is a
+// known HTML entity. html.UnescapeString also decodes known prefixes
+// ("&notit;" becomes "¬it;"), but every full named entity expands to at
+// most two code points, while a prefix match keeps at least the rest of the
+// name and the semicolon.
+func decodeNamedEntity(entity string) (string, bool) {
+	out := html.UnescapeString(entity)
+	if out == entity || utf8.RuneCountInString(out) > 2 {
+		return entity, false
+	}
+	return out, true
+}
+
+// foldEntityCase rewrites named entities whose exact casing is unknown, such
+// as "&NBSP;", to their lowercase form when that is a known entity. Names
+// that are known as written are never touched, so case-sensitive pairs like
+// "&Eacute;" (É) and "&eacute;" (é) keep their distinct meanings.
+func foldEntityCase(text string) string {
+	return namedEntity.ReplaceAllStringFunc(text, func(entity string) string {
+		if _, ok := decodeNamedEntity(entity); ok {
+			return entity
+		}
+		if lower := strings.ToLower(entity); lower != entity {
+			if _, ok := decodeNamedEntity(lower); ok {
+				return lower
+			}
+		}
+		return entity
+	})
+}
+
+func fixHTMLEntities(text string, opts Options) string {
 	// Only decode if it looks like HTML entities are present
 	if !strings.Contains(text, "&") {
 		return text
 	}
-	return html.UnescapeString(text)
+	if opts.CaseInsensitiveEntities {
+		text = foldEntityCase(text)
+	}
+	strict := opts.HTMLEntityMode == HTMLStrict
+	if !opts.StrictAmpersand && !strict {
+		text = repairNumericEntities(text)
+	}
+	if !opts.StrictAmpersand && !strict && opts.MaxEntityExpansions <= 0 {
+		return html.UnescapeString(text)
+	}
+	re := lenientEntity
+	if opts.StrictAmpersand || strict {
+		re = strictEntity
+	}
+	decoded := 0
+	return re.ReplaceAllStringFunc(text, func(entity string) string {
+		if opts.MaxEntityExpansions > 0 && decoded >= opts.MaxEntityExpansions {
+			return entity
+		}
+		out := html.UnescapeString(entity)
+		if strict && !wholeEntity(entity, out) {
+			return entity
+		}
+		if out != entity {
+			decoded++
+		}
+		return out
+	})
+}
+
+// wholeEntity reports whether out, the html.UnescapeString decoding of a
+// semicolon-terminated entity, consumed all of it. The unescaper decodes the
+// longest known name at the start of a word and leaves the rest, semicolon
+// included, so a partial match still ends in ';' unless the entity is &semi;
+// itself. Numeric references must name a valid, non-NUL character.
+func wholeEntity(entity, out string) bool {
+	if out == entity {
+		return false
+	}
+	if entity[1] == '#' {
+		digits, base := entity[2:len(entity)-1], 10
+		if digits[0] == 'x' || digits[0] == 'X' {
+			digits, base = digits[1:], 16
+		}
+		n, err := strconv.ParseInt(digits, base, 32)
+		return err == nil && n != 0 && utf8.ValidRune(rune(n))
+	}
+	return !strings.HasSuffix(out, ";") || out == ";"
 }
 
-func fixLineBreaks(text string) string {
+func fixLineBreaks(text, eol string) string {
 	// Normalize \r\n and \r to \n
 	text = strings.ReplaceAll(text, "\r\n", "\n")
 	text = strings.ReplaceAll(text, "\r", "\n")
 	// Replace Unicode line/paragraph separators
 	text = strings.ReplaceAll(text, "\u2028", "\n")
 	text = strings.ReplaceAll(text, "\u2029", "\n")
+	if eol != "\n" {
+		text = strings.ReplaceAll(text, "\n", eol)
+	}
 	return text
 }
 
+// lineEnding returns the terminator named by a LineEndingStyle.
+func lineEnding(style string) string {
+	switch strings.ToLower(style) {
+	case "crlf":
+		return "\r\n"
+	case "cr":
+		return "\r"
+	}
+	return "\n"
+}
+
+// surrogateAt decodes a UTF-16 surrogate encoded as a three-byte UTF-8
+// sequence (ED A0..BF 80..BF) at text[i:], as CESU-8 and WTF-8 data contain.
+// Go's decoder rejects these bytes, so they have to be matched by hand.
+func surrogateAt(text string, i int) (rune, bool) {
+	if i+2 >= len(text) || text[i] != 0xED || text[i+1] < 0xA0 || text[i+1] > 0xBF ||
+		text[i+2] < 0x80 || text[i+2] > 0xBF {
+		return 0, false
+	}
+	return 0xD000 | rune(text[i+1]&0x3F)<<6 | rune(text[i+2]&0x3F), true
+}
+
+// surrogatePairAt decodes the encoded surrogate pair starting at text[i:],
+// reporting false if there is none. A high surrogate directly followed by a
+// low one is a valid pair, as CESU-8 and WTF-8 data encode astral
+// characters. A low surrogate directly followed by a high one is taken to be
+// a pair swapped by an endianness mix-up, unless that high surrogate begins
+// a correctly ordered pair of its own; then the low one is unpaired.
+func surrogatePairAt(text string, i int) (rune, bool) {
+	s, ok := surrogateAt(text, i)
+	if !ok {
+		return 0, false
+	}
+	next, ok := surrogateAt(text, i+3)
+	if !ok || (s < 0xDC00) == (next < 0xDC00) {
+		return 0, false
+	}
+	if s < 0xDC00 {
+		return utf16.DecodeRune(s, next), true
+	}
+	if after, ok := surrogateAt(text, i+6); ok && after >= 0xDC00 {
+		return 0, false
+	}
+	return utf16.DecodeRune(next, s), true
+}
+
+// fixSurrogates decodes encoded surrogate pairs, including swapped ones (see
+// surrogatePairAt), and replaces unpaired surrogates, and any other invalid
+// bytes, with U+FFFD.
 func fixSurrogates(text string) string {
+	if utf8.ValidString(text) {
+		return text
+	}
 	var b strings.Builder
 	b.Grow(len(text))
-	for _, r := range text {
-		if r >= 0xD800 && r <= 0xDFFF {
+	for i := 0; i < len(text); {
+		if r, ok := surrogatePairAt(text, i); ok {
+			b.WriteRune(r)
+			i += 6
+			continue
+		}
+		if _, ok := surrogateAt(text, i); ok {
 			// unpaired surrogate — replace with replacement char
 			b.WriteRune(unicode.ReplacementChar)
-		} else {
-			b.WriteRune(r)
+			i += 3
+			continue
 		}
+		r, size := utf8.DecodeRuneInString(text[i:])
+		b.WriteRune(r)
+		i += size
 	}
 	return b.String()
 }
 
-func fixControlChars(text string) string {
+// fixControlChars removes C0 and C1 controls other than tab, newline and
+// carriage return, writing replacement in place of each one.
+func fixControlChars(text string, allowed func(rune) bool, replacement string) string {
 	var b strings.Builder
 	b.Grow(len(text))
 	for _, r := range text {
-		// Allow tab, newline, carriage return; strip other C0 and all C1 controls
-		if r == '\t' || r == '\n' || r == '\r' {
+		// Allow tab, newline, carriage return; replace other C0 and all C1 controls
+		if r == '\t' || r == '\n' || r == '\r' || allowed(r) {
 			b.WriteRune(r)
 		} else if r < 0x20 || (r >= 0x7F && r <= 0x9F) {
-			// strip
+			b.WriteString(replacement)
 		} else {
 			b.WriteRune(r)
 		}
@@ -271,21 +1197,312 @@ func fixControlChars(text string) string {
 	return b.String()
 }
 
-var curlyQuoteReplacer = strings.NewReplacer(
+// removeZeroWidth strips invisible characters that carry no meaning but
+// break string comparison. ZWJ and ZWNJ are kept because emoji sequences and
+// several scripts depend on them. The combining grapheme joiner is kept when
+// a combining mark follows it, which is its one legitimate use: blocking
+// canonical reordering between two marks. Allowed runes are always kept.
+func removeZeroWidth(text string, allowed func(rune) bool) string {
+	if !strings.ContainsAny(text, "\u200B\u2060\uFEFF\u034F") {
+		return text
+	}
+	rs := []rune(text)
+	var b strings.Builder
+	b.Grow(len(text))
+	for i, r := range rs {
+		if allowed(r) {
+			b.WriteRune(r)
+			continue
+		}
+		switch r {
+		case '\u200B', '\u2060', '\uFEFF':
+			continue
+		case '\u034F':
+			if i+1 >= len(rs) || !unicode.Is(unicode.Mn, rs[i+1]) {
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// stripBOM removes byte-order marks from the start of text. More than one
+// can pile up there when BOM-prefixed files are concatenated.
+func stripBOM(text string) string {
+	return strings.TrimLeft(text, "\uFEFF")
+}
+
+// collapseWhitespace replaces each run of horizontal whitespace with a single
+// space. Line breaks are never collapsed, and neither are runes keep accepts.
+func collapseWhitespace(text string, keep func(rune) bool) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	inRun := false
+	for _, r := range text {
+		if r == '\n' || r == '\r' || keep(r) || !unicode.IsSpace(r) {
+			b.WriteRune(r)
+			inRun = false
+			continue
+		}
+		if !inRun {
+			b.WriteByte(' ')
+			inRun = true
+		}
+	}
+	return b.String()
+}
+
+// trimTrailingSpace removes whitespace at the end of each line, where a line
+// ends at "\n", "\r" or the end of the text. Runes keep accepts stop the
+// trim, so in "a\t \n" with tabs kept only the space goes.
+func trimTrailingSpace(text string, keep func(rune) bool) string {
+	trim := func(r rune) bool { return r != '\n' && r != '\r' && unicode.IsSpace(r) && !keep(r) }
+	var b strings.Builder
+	b.Grow(len(text))
+	start := 0
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) && text[i] != '\n' && text[i] != '\r' {
+			continue
+		}
+		b.WriteString(strings.TrimRightFunc(text[start:i], trim))
+		if i < len(text) {
+			b.WriteByte(text[i])
+		}
+		start = i + 1
+	}
+	return b.String()
+}
+
+// ensureFinalNewline replaces the line terminators at the end of text with a
+// single eol. Empty text stays empty.
+func ensureFinalNewline(text, eol string) string {
+	if text == "" {
+		return text
+	}
+	return strings.TrimRight(text, "\r\n") + eol
+}
+
+// curlyQuotes maps curly quotes to ASCII, the English ones first.
+var curlyQuotes = []string{
 	"\u2018", "'", // left single quotation mark
 	"\u2019", "'", // right single quotation mark
-	"\u201A", "'", // single low-9 quotation mark
-	"\u201B", "'", // single high-reversed-9 quotation mark
 	"\u201C", `"`, // left double quotation mark
 	"\u201D", `"`, // right double quotation mark
+	"\u201A", "'", // single low-9 quotation mark
+	"\u201B", "'", // single high-reversed-9 quotation mark
 	"\u201E", `"`, // double low-9 quotation mark
 	"\u201F", `"`, // double high-reversed-9 quotation mark
+}
+
+// guillemets maps angle quotation marks to ASCII.
+var guillemets = []string{
 	"\u2039", "<", // single left-pointing angle quotation mark
 	"\u203A", ">", // single right-pointing angle quotation mark
 	"\u00AB", `"`, // left-pointing double angle quotation mark
 	"\u00BB", `"`, // right-pointing double angle quotation mark
+}
+
+var quoteReplacers = map[QuoteStyle]*strings.Replacer{
+	QuoteASCII:              strings.NewReplacer(append(append([]string(nil), curlyQuotes...), guillemets...)...),
+	QuotePreserveGuillemets: strings.NewReplacer(curlyQuotes...),
+	QuoteCurlyToStraight:    strings.NewReplacer(curlyQuotes[:8]...),
+}
+
+// fixCurlyQuotes straightens the quotes style selects. An unknown style is
+// treated as QuoteASCII.
+func fixCurlyQuotes(text string, style QuoteStyle) string {
+	r, ok := quoteReplacers[style]
+	if !ok {
+		r = quoteReplacers[QuoteASCII]
+	}
+	return r.Replace(text)
+}
+
+// smartenQuotes replaces ASCII quotes with curly ones. A quote opens when it
+// starts the text or follows whitespace or opening punctuation, and closes
+// otherwise. An apostrophe between letters ("don't") or before a digit
+// ("'90s") is always U+2019.
+func smartenQuotes(text string) string {
+	if !strings.ContainsAny(text, `'"`) {
+		return text
+	}
+	rs := []rune(text)
+	for i, r := range rs {
+		if r != '\'' && r != '"' {
+			continue
+		}
+		opening := i == 0 || unicode.IsSpace(rs[i-1]) || strings.ContainsRune("([{<\u2014\u2013\u201C\u2018", rs[i-1])
+		next := rune(0)
+		if i+1 < len(rs) {
+			next = rs[i+1]
+		}
+		switch {
+		case r == '"' && opening:
+			rs[i] = '\u201C'
+		case r == '"':
+			rs[i] = '\u201D'
+		case opening && !unicode.IsDigit(next):
+			rs[i] = '\u2018'
+		default:
+			rs[i] = '\u2019'
+		}
+	}
+	return string(rs)
+}
+
+// punctuationReplacer builds the replacer for the punctuation stage from
+// NormalizeEllipsis, EllipsisStyle and PunctuationMap. strings.Replacer tries
+// pairs in argument order at each position, so map keys are sorted longest
+// first to make the result deterministic.
+func punctuationReplacer(opts Options) *strings.Replacer {
+	var pairs []string
+	keys := make([]string, 0, len(opts.PunctuationMap))
+	for k := range opts.PunctuationMap {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		pairs = append(pairs, k, opts.PunctuationMap[k])
+	}
+	if opts.NormalizeEllipsis {
+		if opts.EllipsisStyle == EllipsisDots {
+			pairs = append(pairs, "\u2026", "...")
+		} else {
+			pairs = append(pairs, ". . .", "\u2026", "...", "\u2026")
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// isNumericDash reports whether r is a minus sign or hyphen/dash that
+// strconv and friends expect as ASCII '-' when it precedes a number. The em
+// dash is deliberately excluded: it is prose punctuation, not a sign.
+func isNumericDash(r rune) bool {
+	switch r {
+	case '\u2212', // minus sign
+		'\u2010', // hyphen
+		'\u2011', // non-breaking hyphen
+		'\u2012', // figure dash
+		'\u2013', // en dash
+		'\uFE63', // small hyphen-minus
+		'\uFF0D': // fullwidth hyphen-minus
+		return true
+	}
+	return false
+}
+
+// fixNumericDashes replaces numeric dashes that are immediately followed by a
+// digit ("−5", "5–10") with ASCII '-'. Dashes elsewhere are left alone.
+func fixNumericDashes(text string) string {
+	if !strings.ContainsFunc(text, isNumericDash) {
+		return text
+	}
+	rs := []rune(text)
+	for i, r := range rs {
+		if isNumericDash(r) && i+1 < len(rs) && unicode.IsDigit(rs[i+1]) {
+			rs[i] = '-'
+		}
+	}
+	return string(rs)
+}
+
+var (
+	// emDashSpaced matches an em dash with the horizontal space around it.
+	emDashSpaced = regexp.MustCompile(`[ \t]*\x{2014}[ \t]*`)
+	// sentenceRun matches sentence-ending punctuation after a lowercase
+	// letter or closing bracket or quote, with any closing quotes or
+	// brackets after it, the horizontal space that follows, and the
+	// uppercase letter starting the next sentence.
+	sentenceRun = regexp.MustCompile(`([\p{Ll})"'\x{2019}\x{201D}][.!?]+["')\x{2019}\x{201D}]*)[ \t]*(\p{Lu})`)
 )
 
-func fixCurlyQuotes(text string) string {
-	return curlyQuoteReplacer.Replace(text)
-}
\ No newline at end of file
+// tidyPunctuationSpacing normalizes spacing around punctuation:
+//
+//   - an em dash with space on either side gets exactly one space on each
+//     side ("word —word" becomes "word — word"); a closed-up em dash
+//     ("word—word") is a deliberate style and is left alone, and no space
+//     is added at the start or end of a line;
+//   - sentence-ending punctuation after a lowercase letter gets exactly one
+//     space before an uppercase letter ("end.Next", "end.   Next" become
+//     "end. Next"). Lowercase or digits after the stop ("a.b", "e.g.",
+//     "3.14", "example.com") are left alone.
+//
+// A run of space holding a rune keep reports (a delimiting tab, an
+// allowlisted space) is left exactly as it is.
+func tidyPunctuationSpacing(text string, keep func(rune) bool) string {
+	if strings.Contains(text, "\u2014") {
+		var b strings.Builder
+		b.Grow(len(text))
+		last := 0
+		for _, loc := range emDashSpaced.FindAllStringIndex(text, -1) {
+			start, end := loc[0], loc[1]
+			if end-start == len("\u2014") {
+				continue
+			}
+			dash := start + strings.Index(text[start:end], "\u2014")
+			before, after := text[start:dash], text[dash+len("\u2014"):end]
+			b.WriteString(text[last:start])
+			switch {
+			case strings.ContainsFunc(before, keep):
+				b.WriteString(before)
+			case start > 0 && text[start-1] != '\n' && text[start-1] != '\r':
+				b.WriteByte(' ')
+			}
+			b.WriteString("\u2014")
+			switch {
+			case strings.ContainsFunc(after, keep):
+				b.WriteString(after)
+			case end < len(text) && text[end] != '\n' && text[end] != '\r':
+				b.WriteByte(' ')
+			}
+			last = end
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+
+	matches := sentenceRun.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	last := 0
+	for _, m := range matches {
+		gapStart, gapEnd := m[3], m[4]
+		if strings.ContainsFunc(text[gapStart:gapEnd], keep) {
+			continue
+		}
+		b.WriteString(text[last:gapStart])
+		b.WriteByte(' ')
+		last = gapEnd
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// romanNumeralReplacer maps the Number Forms Roman numerals to ASCII letters.
+// NFKC does the same, but this fold is controllable on its own.
+var romanNumeralReplacer = func() *strings.Replacer {
+	upper := []string{"I", "II", "III", "IV", "V", "VI", "VII", "VIII",
+		"IX", "X", "XI", "XII", "L", "C", "D", "M"}
+	var pairs []string
+	for i, s := range upper {
+		pairs = append(pairs,
+			string(rune(0x2160+i)), s,
+			string(rune(0x2170+i)), strings.ToLower(s))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+func foldRomanNumerals(text string) string {
+	return romanNumeralReplacer.Replace(text)
+}
@@ -0,0 +1,106 @@
+package goftfy
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// graphemes splits text into user-perceived characters. It approximates
+// Unicode extended grapheme clusters (UAX #29) closely enough for Latin,
+// Cyrillic, Greek and emoji text: CRLF stays together, combining marks,
+// variation selectors, skin-tone modifiers and keycaps attach to their base,
+// a ZWJ glues the following character on, and regional indicators pair up
+// into flags.
+func graphemes(text string) []string {
+	var out []string
+	for i := 0; i < len(text); {
+		n := graphemeLen(text[i:])
+		out = append(out, text[i:i+n])
+		i += n
+	}
+	return out
+}
+
+// graphemeLen returns the byte length of the grapheme cluster at the start
+// of s, which must not be empty.
+func graphemeLen(s string) int {
+	r, n := utf8.DecodeRuneInString(s)
+	if r == '\r' && n < len(s) && s[n] == '\n' {
+		return n + 1
+	}
+	if r == '\n' || r == '\r' {
+		return n
+	}
+	if isRegionalIndicator(r) {
+		if r2, n2 := utf8.DecodeRuneInString(s[n:]); isRegionalIndicator(r2) {
+			n += n2
+		}
+	}
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		switch {
+		case r == '\u200D':
+			n += size
+			if n < len(s) {
+				_, next := utf8.DecodeRuneInString(s[n:])
+				n += next
+			}
+		case isGraphemeExtender(r):
+			n += size
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// isGraphemeExtender reports whether r attaches to the preceding character.
+func isGraphemeExtender(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r >= 0xFE00 && r <= 0xFE0F: // variation selectors
+		return true
+	case isSkinToneModifier(r):
+		return true
+	case r >= 0xE0020 && r <= 0xE007F: // emoji tag sequences
+		return true
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// FixAligned fixes text with opts and returns, for every grapheme of the
+// original, the index of the grapheme in the fixed text it corresponds to,
+// as [original, fixed] pairs in original order. Graphemes that were merged
+// or replaced ("Ã©" becoming "é") map to the replacement's graphemes in
+// order, with any surplus mapping to its last one; deleted graphemes map to
+// the position where the deletion happened. This keeps caption or subtitle
+// timing tied to character positions accurate after fixing.
+func FixAligned(text string, opts Options) (string, [][2]int) {
+	fixed := FixWithOptions(text, opts)
+	a, b := graphemes(text), graphemes(fixed)
+	align := make([][2]int, 0, len(a))
+
+	i, j := 0, 0
+	for _, h := range diffHunks(a, b) {
+		for ; i < h.aStart; i, j = i+1, j+1 {
+			align = append(align, [2]int{i, j})
+		}
+		for k := 0; i < h.aEnd; i, k = i+1, k+1 {
+			target := h.bStart
+			if h.bEnd > h.bStart {
+				target += min(k, h.bEnd-h.bStart-1)
+			}
+			align = append(align, [2]int{i, target})
+		}
+		j = h.bEnd
+	}
+	for ; i < len(a); i, j = i+1, j+1 {
+		align = append(align, [2]int{i, j})
+	}
+	return fixed, align
+}
@@ -0,0 +1,74 @@
+package goftfy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FixJSON fixes every string value in the JSON document data, however
+// deeply nested in objects and arrays, and returns the re-encoded document.
+// Object keys are fixed too when opts.FixKeys is set; it is an error if two
+// keys of one object fix to the same key. Numbers keep their exact text,
+// and booleans and nulls are untouched. The output is compact, with object
+// keys sorted as encoding/json writes them, and characters such as '&' and
+// '<' are not escaped.
+func FixJSON(data []byte, opts Options) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("goftfy: FixJSON: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("goftfy: FixJSON: unexpected data after top-level value")
+	}
+	fixer := NewFixer(opts.fragment())
+	v, err := fixJSONValue(v, fixer, opts.FixKeys)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("goftfy: FixJSON: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// fixJSONValue fixes the strings in a value decoded by encoding/json.
+func fixJSONValue(v any, fixer *Fixer, fixKeys bool) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return fixer.Fix(v), nil
+	case []any:
+		for i, elem := range v {
+			fixed, err := fixJSONValue(elem, fixer, fixKeys)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = fixed
+		}
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, elem := range v {
+			fixed, err := fixJSONValue(elem, fixer, fixKeys)
+			if err != nil {
+				return nil, err
+			}
+			if fixKeys {
+				fk := fixer.Fix(k)
+				if _, dup := out[fk]; dup {
+					return nil, fmt.Errorf("goftfy: FixJSON: two keys fix to %q", fk)
+				}
+				k = fk
+			}
+			out[k] = fixed
+		}
+		return out, nil
+	}
+	return v, nil
+}
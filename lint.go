@@ -0,0 +1,112 @@
+package goftfy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"unicode/utf8"
+)
+
+// Problem is one suspected corruption found by Lint.
+type Problem struct {
+	// Offset is the byte offset of Text in the linted string.
+	Offset int
+	// Text is the offending text.
+	Text string
+	// Category classifies the problem: "mojibake" or one of the CharInfo
+	// categories for built-in detectors, anything for registered ones.
+	Category string
+	// Message describes the problem for humans.
+	Message string
+}
+
+// Detector finds problems in text. Implementations must be safe for
+// concurrent use and report offsets into the text they were given.
+type Detector interface {
+	Detect(text string) []Problem
+}
+
+// DetectorFunc adapts an ordinary function to the Detector interface.
+type DetectorFunc func(text string) []Problem
+
+// Detect calls f(text).
+func (f DetectorFunc) Detect(text string) []Problem {
+	return f(text)
+}
+
+var (
+	detectorsMu sync.RWMutex
+	detectors   = []Detector{DetectorFunc(detectBuiltin)}
+)
+
+// RegisterDetector adds d to the detectors Lint consults, after the built-in
+// ones. It is typically called from an init function of a plugin package.
+func RegisterDetector(d Detector) {
+	detectorsMu.Lock()
+	defer detectorsMu.Unlock()
+	detectors = append(detectors, d)
+}
+
+// Lint runs every detector over text and returns the problems found,
+// ordered by offset. Clean text yields nil.
+func Lint(text string) []Problem {
+	detectorsMu.RLock()
+	ds := detectors
+	detectorsMu.RUnlock()
+
+	var problems []Problem
+	for _, d := range ds {
+		problems = append(problems, d.Detect(text)...)
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Offset < problems[j].Offset })
+	return problems
+}
+
+// detectBuiltin reports recognizable mojibake sequences (see ScanPatterns)
+// and the characters AnalyzeString considers problematic.
+func detectBuiltin(text string) []Problem {
+	var problems []Problem
+	rs := []rune(text)
+	// offsets[i] is the byte offset of rs[i]; an invalid byte is one rune
+	// but only one byte long.
+	offsets := runeOffsets(text)
+	for i := 0; i < len(rs); {
+		if n, r := mojibakeAt(rs, i); n > 0 {
+			broken := text[offsets[i]:offsets[i+n]]
+			problems = append(problems, Problem{
+				Offset:   offsets[i],
+				Text:     broken,
+				Category: "mojibake",
+				Message:  fmt.Sprintf("%q is mojibake for %q", broken, r),
+			})
+			i += n
+			continue
+		}
+		if info := analyzeRune(rs, i); info.IsProblematic {
+			problems = append(problems, Problem{
+				Offset:   offsets[i],
+				Text:     text[offsets[i]:offsets[i+1]],
+				Category: info.Category,
+				Message:  fmt.Sprintf("%s %U", info.Category, rs[i]),
+			})
+		}
+		i++
+	}
+	return problems
+}
+
+// confidence estimates how likely text is to be clean, correctly decoded
+// text, from 0 (every rune is suspect) to 1 (nothing suspect): the share of
+// runes not covered by a problem the built-in detectors report. Empty text
+// scores 1.
+func confidence(text string) float64 {
+	total := utf8.RuneCountInString(text)
+	if total == 0 {
+		return 1
+	}
+	suspect := 0
+	for _, p := range detectBuiltin(text) {
+		suspect += utf8.RuneCountInString(p.Text)
+	}
+	return 1 - float64(suspect)/float64(total)
+}
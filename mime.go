@@ -0,0 +1,202 @@
+package goftfy
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// encodedWordDecoder decodes RFC 2047 encoded-words, resolving charsets the
+// standard library does not know about through golang.org/x/text.
+var encodedWordDecoder = &mime.WordDecoder{
+	CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+		enc, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, fmt.Errorf("goftfy: unsupported charset %q: %w", charset, err)
+		}
+		return enc.NewDecoder().Reader(input), nil
+	},
+}
+
+// FixEncodedWord decodes the RFC 2047 encoded-words in an email header value
+// (both Q and B encodings, any mix of charsets, adjacent words joined) and
+// then applies Fix to the result, so mojibake hidden inside the encoding is
+// repaired too.
+//
+//	goftfy.FixEncodedWord("=?UTF-8?Q?caf=C3=A9?=") // "café"
+func FixEncodedWord(header string) (string, error) {
+	decoded, err := encodedWordDecoder.DecodeHeader(header)
+	if err != nil {
+		return header, err
+	}
+	return Fix(decoded), nil
+}
+
+// FixBase64 decodes base64-encoded text, fixes it with opts and returns the
+// fixed text. Standard and URL-safe alphabets are accepted, padded or not,
+// and line breaks inside the input are ignored. Malformed input (bad
+// characters or bad padding) returns an error.
+func FixBase64(encoded string, opts Options) (string, error) {
+	raw, _, err := decodeBase64(encoded)
+	if err != nil {
+		return "", err
+	}
+	return FixWithOptions(string(raw), opts), nil
+}
+
+// FixBase64Encoded is like FixBase64 but re-encodes the fixed text using the
+// same alphabet and padding style as the input.
+func FixBase64Encoded(encoded string, opts Options) (string, error) {
+	raw, enc, err := decodeBase64(encoded)
+	if err != nil {
+		return "", err
+	}
+	return enc.EncodeToString([]byte(FixWithOptions(string(raw), opts))), nil
+}
+
+// decodeBase64 decodes s, picking the alphabet and padding style from its
+// contents, and reports which encoding it used.
+func decodeBase64(s string) ([]byte, *base64.Encoding, error) {
+	s = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+
+	enc := base64.StdEncoding
+	if strings.ContainsAny(s, "-_") {
+		enc = base64.URLEncoding
+	}
+	if !strings.HasSuffix(s, "=") && len(s)%4 != 0 {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+	raw, err := enc.DecodeString(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("goftfy: invalid base64: %w", err)
+	}
+	return raw, enc, nil
+}
+
+// FixMultipartForm fixes every text field value of a parsed multipart form,
+// such as the one http.Request.ParseMultipartForm leaves in MultipartForm,
+// with opts in place. File parts are left untouched. A nil form is ignored.
+func FixMultipartForm(form *multipart.Form, opts Options) {
+	if form == nil {
+		return
+	}
+	for _, values := range form.Value {
+		for i, v := range values {
+			values[i] = FixWithOptions(v, opts.fragment())
+		}
+	}
+}
+
+// decodePercentEncoding decodes runs of %XX escapes that spell UTF-8 text
+// containing at least one non-ASCII byte, such as "caf%C3%A9". Runs of
+// ASCII-only escapes ("100%25", "a%20b") and runs that are not valid UTF-8
+// are left as they are, so URLs and literal percent signs survive.
+func decodePercentEncoding(text string) string {
+	if !strings.Contains(text, "%") {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(text); {
+		var run []byte
+		j := i
+		for j+2 < len(text) && text[j] == '%' && isHex(text[j+1]) && isHex(text[j+2]) {
+			run = append(run, unhex(text[j+1])<<4|unhex(text[j+2]))
+			j += 3
+		}
+		if j == i {
+			b.WriteByte(text[i])
+			i++
+			continue
+		}
+		if utf8.Valid(run) && !isASCII(string(run)) {
+			b.Write(run)
+		} else {
+			b.WriteString(text[i:j])
+		}
+		i = j
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// ErrUnmappable is returned, wrapped, by FixAndEncodeWith with
+// UnmappableError when the fixed text holds a character the target encoding
+// cannot represent.
+var ErrUnmappable = errors.New("goftfy: character not representable in target encoding")
+
+// UnmappablePolicy selects what FixAndEncodeWith does with characters the
+// target encoding cannot represent.
+type UnmappablePolicy int
+
+const (
+	// UnmappableReplace writes '?' in place of each such character.
+	UnmappableReplace UnmappablePolicy = iota
+	// UnmappableDrop leaves such characters out.
+	UnmappableDrop
+	// UnmappableError fails with an error wrapping ErrUnmappable.
+	UnmappableError
+)
+
+// FixAndEncode applies Fix and encodes the result in targetEncoding, any
+// name known to the WHATWG encoding standard such as "windows-1252", for
+// handing text to a legacy system. Characters the encoding cannot represent
+// become '?'. It returns an error only for an unknown encoding.
+func FixAndEncode(text, targetEncoding string) ([]byte, error) {
+	return FixAndEncodeWith(text, targetEncoding, UnmappableReplace)
+}
+
+// FixAndEncodeWith is FixAndEncode with a choice of what happens to
+// characters targetEncoding cannot represent.
+func FixAndEncodeWith(text, targetEncoding string, policy UnmappablePolicy) ([]byte, error) {
+	enc, err := htmlindex.Get(targetEncoding)
+	if err != nil {
+		return nil, fmt.Errorf("goftfy: unsupported charset %q: %w", targetEncoding, err)
+	}
+	fixed := Fix(text)
+	encoder := enc.NewEncoder()
+	if out, err := encoder.Bytes([]byte(fixed)); err == nil {
+		return out, nil
+	}
+	// Some character does not fit: encode rune by rune to find them.
+	out := make([]byte, 0, len(fixed))
+	for i, r := range fixed {
+		b, err := encoder.String(string(r))
+		if err == nil {
+			out = append(out, b...)
+			continue
+		}
+		switch policy {
+		case UnmappableReplace:
+			out = append(out, '?')
+		case UnmappableError:
+			return nil, fmt.Errorf("%w: %q at byte %d of the fixed text", ErrUnmappable, r, i)
+		}
+	}
+	return out, nil
+}
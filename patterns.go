@@ -0,0 +1,141 @@
+package goftfy
+
+import (
+	"slices"
+	"sort"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// PatternMatch is a mojibake sequence found in text, the character it stands
+// for, and how many times it occurs.
+type PatternMatch struct {
+	Broken string
+	Fixed  string
+	Count  int
+}
+
+// mojibakeByte returns the byte that r was decoded from when UTF-8 bytes were
+// misread as Latin-1 or Windows-1252.
+func mojibakeByte(r rune) (byte, bool) {
+	if r < 0x100 {
+		return byte(r), true
+	}
+	return charmap.Windows1252.EncodeRune(r)
+}
+
+// mojibakeAt reports the mojibake sequence starting at rs[i], if any: a
+// UTF-8 lead byte followed by the continuation bytes of one non-ASCII
+// character, each shown as its Latin-1 or Windows-1252 character. It returns
+// the number of runes the sequence spans and the character it decodes to.
+func mojibakeAt(rs []rune, i int) (int, rune) {
+	lead, ok := mojibakeByte(rs[i])
+	if !ok || lead < 0xC2 || lead > 0xF4 {
+		return 0, 0
+	}
+	n := 2
+	switch {
+	case lead >= 0xF0:
+		n = 4
+	case lead >= 0xE0:
+		n = 3
+	}
+	if i+n > len(rs) {
+		return 0, 0
+	}
+	buf := []byte{lead}
+	for _, r := range rs[i+1 : i+n] {
+		b, ok := mojibakeByte(r)
+		if !ok || b < 0x80 || b > 0xBF {
+			return 0, 0
+		}
+		buf = append(buf, b)
+	}
+	r, size := utf8.DecodeRune(buf)
+	if r == utf8.RuneError || size != n {
+		return 0, 0
+	}
+	return n, r
+}
+
+// ScanPatterns finds every character-level mojibake sequence in text, such
+// as "Ã©" for "é" or "â€™" for "’", and counts how often each occurs. The
+// result is ordered by count, most frequent first, with ties in order of
+// first appearance.
+func ScanPatterns(text string) []PatternMatch {
+	rs := []rune(text)
+	var matches []PatternMatch
+	index := make(map[string]int)
+	for i := 0; i < len(rs); {
+		n, r := mojibakeAt(rs, i)
+		if n == 0 {
+			i++
+			continue
+		}
+		broken := string(rs[i : i+n])
+		if k, ok := index[broken]; ok {
+			matches[k].Count++
+		} else {
+			index[broken] = len(matches)
+			matches = append(matches, PatternMatch{Broken: broken, Fixed: string(r), Count: 1})
+		}
+		i += n
+	}
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].Count > matches[b].Count })
+	return matches
+}
+
+// TopPattern returns the most frequent mojibake sequence in text (see
+// ScanPatterns), what it should read as, and how many times it occurs, for
+// "did you mean" messages such as: found "Ã©" 5 times, meaning "é". It
+// returns "", "", 0 when text contains no recognizable mojibake.
+func TopPattern(text string) (broken, fixed string, count int) {
+	matches := ScanPatterns(text)
+	if len(matches) == 0 {
+		return "", "", 0
+	}
+	return matches[0].Broken, matches[0].Fixed, matches[0].Count
+}
+
+// maxLayers bounds how many rounds of mojibake DetectLayers peels off.
+const maxLayers = 8
+
+// DetectLayers reports how deeply each mojibake character in text is
+// encoded: the result maps a depth to the number of characters recovered at
+// that depth, so {1: 10, 2: 3} means ten characters were mis-decoded once and
+// three twice ("Ã©" and "ÃƒÂ©" for "é"). It peels one layer at a time by
+// decoding every sequence ScanPatterns would find, tracking for each
+// resulting character the deepest layer it came from. Clean text yields an
+// empty map.
+func DetectLayers(text string) map[int]int {
+	rs := []rune(text)
+	depths := make([]int, len(rs))
+	for layer := 0; layer < maxLayers; layer++ {
+		var nextRunes []rune
+		var nextDepths []int
+		for i := 0; i < len(rs); {
+			n, r := mojibakeAt(rs, i)
+			if n == 0 {
+				nextRunes = append(nextRunes, rs[i])
+				nextDepths = append(nextDepths, depths[i])
+				i++
+				continue
+			}
+			nextRunes = append(nextRunes, r)
+			nextDepths = append(nextDepths, slices.Max(depths[i:i+n])+1)
+			i += n
+		}
+		if len(nextRunes) == len(rs) {
+			break
+		}
+		rs, depths = nextRunes, nextDepths
+	}
+	layers := make(map[int]int)
+	for _, d := range depths {
+		if d > 0 {
+			layers[d]++
+		}
+	}
+	return layers
+}
@@ -0,0 +1,44 @@
+package goftfy
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// corpusEntry is one NDJSON line written by FixCorpusReport.
+type corpusEntry struct {
+	Index   int      `json:"index"`
+	Changed bool     `json:"changed"`
+	Stages  []string `json:"stages"`
+	Before  string   `json:"before"`
+	After   string   `json:"after"`
+}
+
+// FixCorpusReport fixes every string in texts with opts and writes one JSON
+// object per line to w for each entry that changed:
+//
+//	{"index":3,"changed":true,"stages":["encoding"],"before":"cafÃ©","after":"café"}
+//
+// Unchanged entries are omitted, so the output is a reviewable changelog of
+// a bulk cleanup. It returns the first write error.
+func FixCorpusReport(texts []string, w io.Writer, opts Options) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for i, text := range texts {
+		fixed, stages := fixTracked(text, opts)
+		if fixed == text {
+			continue
+		}
+		err := enc.Encode(corpusEntry{
+			Index:   i,
+			Changed: true,
+			Stages:  stages,
+			Before:  text,
+			After:   fixed,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package goftfy
+
+import "unicode"
+
+// Segment is a run of text written in a single script.
+type Segment struct {
+	// Script is "Latin", "Cyrillic", "Greek", "Arabic", "Hebrew",
+	// "Devanagari", "Thai", "CJK" (Han, kana, Hangul and Bopomofo),
+	// "Other" for any other script, or "Common" for a text made only of
+	// digits, punctuation, symbols and whitespace.
+	Script string
+	// Text is the segment's text, a substring of the input.
+	Text string
+	// Offset is the byte offset of Text within the input.
+	Offset int
+}
+
+// segmentScripts lists the scripts SegmentByScript tells apart.
+var segmentScripts = []struct {
+	name   string
+	tables []*unicode.RangeTable
+}{
+	{"Latin", []*unicode.RangeTable{unicode.Latin}},
+	{"Cyrillic", []*unicode.RangeTable{unicode.Cyrillic}},
+	{"Greek", []*unicode.RangeTable{unicode.Greek}},
+	{"Arabic", []*unicode.RangeTable{unicode.Arabic}},
+	{"Hebrew", []*unicode.RangeTable{unicode.Hebrew}},
+	{"Devanagari", []*unicode.RangeTable{unicode.Devanagari}},
+	{"Thai", []*unicode.RangeTable{unicode.Thai}},
+	{"CJK", []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Bopomofo}},
+}
+
+// scriptOf returns the segment script of r, or "" for Common and Inherited
+// runes (digits, punctuation, symbols, spaces and combining marks), which
+// take the script of the text around them.
+func scriptOf(r rune) string {
+	if unicode.In(r, unicode.Common, unicode.Inherited) {
+		return ""
+	}
+	for _, s := range segmentScripts {
+		if unicode.In(r, s.tables...) {
+			return s.name
+		}
+	}
+	if r == unicode.ReplacementChar || !unicode.IsGraphic(r) {
+		return ""
+	}
+	return "Other"
+}
+
+// SegmentByScript splits text into maximal runs of a single script. Common
+// and Inherited characters join the run they follow (or, at the start of the
+// text, the run they precede), so "Hello, мир!" becomes "Hello, " (Latin) and
+// "мир!" (Cyrillic). Concatenating the segments' Text yields text again.
+func SegmentByScript(text string) []Segment {
+	var segs []Segment
+	cur := Segment{}
+	for i, r := range text {
+		s := scriptOf(r)
+		switch {
+		case s == "" || s == cur.Script:
+		case cur.Script == "":
+			cur.Script = s
+		default:
+			cur.Text = text[cur.Offset:i]
+			segs = append(segs, cur)
+			cur = Segment{Script: s, Offset: i}
+		}
+	}
+	if cur.Script == "" {
+		cur.Script = "Common"
+	}
+	cur.Text = text[cur.Offset:]
+	if cur.Text != "" || len(segs) == 0 {
+		segs = append(segs, cur)
+	}
+	return segs
+}
@@ -0,0 +1,41 @@
+package goftfy
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	statsEnabled atomic.Bool
+	statsOnce    sync.Once
+	statFixes    = new(expvar.Int)
+	statBytes    = new(expvar.Int)
+	statStages   = new(expvar.Map).Init()
+)
+
+// EnableExpvar publishes running totals under the expvar name "goftfy":
+// "fixes" (FixWithOptions calls, including those made by Fix and the batch
+// helpers), "bytes" (input bytes processed) and "stages" (per stage name, how
+// many calls that stage changed text in). Counting is off until the first
+// call, so importing the package has no side effects; later calls are no-ops.
+func EnableExpvar() {
+	statsOnce.Do(func() {
+		m := expvar.NewMap("goftfy")
+		m.Set("fixes", statFixes)
+		m.Set("bytes", statBytes)
+		m.Set("stages", statStages)
+		statsEnabled.Store(true)
+	})
+}
+
+// fixCounted is FixWithOptions with expvar accounting.
+func fixCounted(text string, opts Options) string {
+	statFixes.Add(1)
+	statBytes.Add(int64(len(text)))
+	fixed, stages := fixTracked(text, opts)
+	for _, name := range stages {
+		statStages.Add(name, 1)
+	}
+	return fixed
+}
@@ -0,0 +1,259 @@
+package goftfy
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// streamWindow is the most input a StreamFixer holds back while waiting to
+// see how a trailing word ends. Mojibake sequences and entities are far
+// shorter, so only pathological whitespace-free input is ever force-split.
+const streamWindow = 256
+
+// StreamFixer fixes a continuous UTF-8 stream that arrives in arbitrary
+// pieces, such as network packets. It holds back the trailing whitespace and
+// word of the input seen so far, because a multi-byte rune, a mojibake
+// sequence, an HTML entity or a CRLF pair may continue in the next piece.
+// Memory use is bounded by streamWindow plus the size of a single Push.
+// EnsureFinalNewline applies to the stream as a whole, at Flush.
+//
+// A StreamFixer is not safe for concurrent use.
+type StreamFixer struct {
+	// OnChunk, if set, is called with every chunk Push or Flush emits and a
+	// confidence between 0 and 1 that the chunk arrived as clean text: the
+	// share of its runes that are not part of a suspected problem. Real-time
+	// pipelines can use it to route doubtful regions for review.
+	OnChunk func(fixed string, confidence float64)
+
+	opts    Options
+	pending []byte
+	// finalEOL is what Flush ends the stream with under
+	// EnsureFinalNewline, or "" when that option is off.
+	finalEOL string
+	// unterminated reports whether the output so far is non-empty and does
+	// not end with a line terminator.
+	unterminated bool
+}
+
+// NewStreamFixer returns a StreamFixer that fixes text with opts.
+func NewStreamFixer(opts Options) *StreamFixer {
+	s := &StreamFixer{opts: opts.fragment()}
+	if opts.EnsureFinalNewline {
+		s.finalEOL = lineEnding(opts.LineEndingStyle)
+	}
+	return s
+}
+
+// Push appends b to the stream and returns the fixed form of the input that
+// is now safe to emit, which may be empty.
+func (s *StreamFixer) Push(b []byte) []byte {
+	s.pending = append(s.pending, b...)
+	return s.emit(streamSplit(s.pending), false)
+}
+
+// Flush returns the fixed form of everything still held back. Call it once
+// the stream has ended.
+func (s *StreamFixer) Flush() []byte {
+	return s.emit(len(s.pending), true)
+}
+
+// emit fixes and returns pending[:n], keeping the rest for later. At the
+// end of the stream it also applies EnsureFinalNewline.
+func (s *StreamFixer) emit(n int, end bool) []byte {
+	chunk := string(s.pending[:n])
+	fixed := ""
+	if n > 0 {
+		fixed = FixWithOptions(chunk, s.opts)
+	}
+	if end && s.finalEOL != "" {
+		if fixed != "" {
+			fixed = ensureFinalNewline(fixed, s.finalEOL)
+		} else if s.unterminated {
+			fixed = s.finalEOL
+		}
+	}
+	if n == 0 && fixed == "" {
+		return nil
+	}
+	if fixed != "" {
+		s.unterminated = !strings.HasSuffix(fixed, "\n") && !strings.HasSuffix(fixed, "\r")
+	}
+	if s.OnChunk != nil {
+		s.OnChunk(fixed, confidence(chunk))
+	}
+	out := []byte(fixed)
+	s.pending = append(s.pending[:0], s.pending[n:]...)
+	return out
+}
+
+// fixReaderChunk is how much FixReader reads from its source at a time.
+const fixReaderChunk = 32 * 1024
+
+// FixReader returns a reader that yields the fixed form of everything read
+// from r, for files too large to hold in memory. It is built on StreamFixer,
+// so a multi-byte rune, mojibake sequence, entity or CRLF split between two
+// reads is still repaired; at most streamWindow bytes of input plus one read
+// are buffered. Read errors from r other than io.EOF are returned as-is.
+func FixReader(r io.Reader, opts Options) io.Reader {
+	return &fixReader{r: r, sf: NewStreamFixer(opts)}
+}
+
+type fixReader struct {
+	r   io.Reader
+	sf  *StreamFixer
+	buf []byte // read buffer for r
+	out []byte // fixed output not yet returned
+	err error
+}
+
+func (f *fixReader) Read(p []byte) (int, error) {
+	for len(f.out) == 0 && f.err == nil {
+		if f.buf == nil {
+			f.buf = make([]byte, fixReaderChunk)
+		}
+		n, err := f.r.Read(f.buf)
+		f.out = append(f.out, f.sf.Push(f.buf[:n])...)
+		if err != nil {
+			f.err = err
+			if err == io.EOF {
+				f.out = append(f.out, f.sf.Flush()...)
+			}
+		}
+	}
+	n := copy(p, f.out)
+	f.out = f.out[n:]
+	if len(f.out) == 0 && f.err != nil {
+		return n, f.err
+	}
+	return n, nil
+}
+
+// NewFixWriter returns a writer that writes the fixed form of everything
+// written to it to w. Like FixReader it holds back a small tail of the input
+// that may continue in the next write; Close flushes that tail. Close does
+// not close w.
+func NewFixWriter(w io.Writer, opts Options) io.WriteCloser {
+	return &fixWriter{w: w, sf: NewStreamFixer(opts)}
+}
+
+type fixWriter struct {
+	w  io.Writer
+	sf *StreamFixer
+}
+
+func (f *fixWriter) Write(p []byte) (int, error) {
+	if out := f.sf.Push(p); len(out) > 0 {
+		if _, err := f.w.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (f *fixWriter) Close() error {
+	if out := f.sf.Flush(); len(out) > 0 {
+		_, err := f.w.Write(out)
+		return err
+	}
+	return nil
+}
+
+// FixPrefix fixes the leading part of text that can be fixed without
+// knowing what follows and reports how many bytes of text it consumed. Like
+// StreamFixer it holds back the trailing word and the whitespace before it,
+// which may be a partial rune, mojibake sequence, entity or CRLF pair, so an
+// incremental parser can call FixPrefix again once text[consumed:] has been
+// extended. Pass the final remainder to FixWithOptions at end of input;
+// EnsureFinalNewline only applies there.
+func FixPrefix(text string, opts Options) (fixed string, consumed int) {
+	consumed = streamSplit([]byte(text))
+	if consumed == 0 {
+		return "", 0
+	}
+	return FixWithOptions(text[:consumed], opts.fragment()), consumed
+}
+
+// streamSplit returns how many leading bytes of b can be fixed without
+// knowing what follows: everything before the final run of whitespace and
+// the word after it. If that would hold back more than streamWindow bytes,
+// the split is forced at a rune boundary instead.
+func streamSplit(b []byte) int {
+	i := len(b)
+	for i > 0 && !isASCIISpace(b[i-1]) {
+		i--
+	}
+	for i > 0 && isASCIISpace(b[i-1]) {
+		i--
+	}
+	if len(b)-i > streamWindow {
+		i = len(b) - streamWindow
+		for i > 0 && !utf8.RuneStart(b[i]) {
+			i--
+		}
+	}
+	return i
+}
+
+func isASCIISpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\f', '\v':
+		return true
+	}
+	return false
+}
+
+// FixWordSplitFunc returns a bufio.SplitFunc for use with bufio.Scanner.Split
+// that yields whitespace-separated words, each already fixed with opts.
+// Words consisting only of characters the fix removes are skipped.
+//
+// Unlike bufio.ScanWords it does not split on U+00A0 or U+0085: both are
+// common continuation bytes inside mojibake ("Ã\u00a0" is a misread "à"),
+// so splitting there would tear the pattern apart before it can be fixed.
+func FixWordSplitFunc(opts Options) bufio.SplitFunc {
+	opts = opts.fragment()
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := scanWords(data, atEOF)
+		if err != nil || token == nil {
+			return advance, token, err
+		}
+		fixed := FixWithOptions(string(token), opts)
+		if fixed == "" {
+			return advance, nil, nil
+		}
+		return advance, []byte(fixed), nil
+	}
+}
+
+// scanWords is bufio.ScanWords with isWordSpace as the separator predicate.
+func scanWords(data []byte, atEOF bool) (int, []byte, error) {
+	start := 0
+	for start < len(data) {
+		r, width := utf8.DecodeRune(data[start:])
+		if !isWordSpace(r) {
+			break
+		}
+		start += width
+	}
+	for i := start; i < len(data); {
+		r, width := utf8.DecodeRune(data[i:])
+		if isWordSpace(r) {
+			return i + width, data[start:i], nil
+		}
+		i += width
+	}
+	if atEOF && len(data) > start {
+		return len(data), data[start:], nil
+	}
+	return start, nil, nil
+}
+
+// isWordSpace reports whether r separates words for FixWordSplitFunc.
+func isWordSpace(r rune) bool {
+	if r == '\u00A0' || r == '\u0085' {
+		return false
+	}
+	return unicode.IsSpace(r)
+}
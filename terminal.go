@@ -0,0 +1,46 @@
+package goftfy
+
+import "strings"
+
+// FixForTerminal prepares untrusted text for printing to a terminal. It
+// applies the default fixes and strips ANSI escape sequences, but instead of
+// silently dropping the remaining control characters it renders them in
+// caret notation, as cat -v does: BEL becomes "^G", a stray ESC "^[", DEL
+// "^?", and C1 controls such as U+009B (CSI) "M-^[". Newlines and tabs are
+// kept, so nothing in the result can move the cursor arbitrarily or change
+// terminal state, yet the reader can still see that something was there.
+func FixForTerminal(text string) string {
+	opts := DefaultOptions()
+	opts.RemoveTerminalEscapes = true
+	opts.FixControlChars = false
+	return caretNotation(FixWithOptions(text, opts))
+}
+
+// caretNotation renders C0 controls other than newline and tab, DEL and C1
+// controls visibly.
+func caretNotation(text string) string {
+	if !strings.ContainsFunc(text, isTerminalControl) {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text) + 8)
+	for _, r := range text {
+		switch {
+		case !isTerminalControl(r):
+			b.WriteRune(r)
+		case r == 0x7F:
+			b.WriteString("^?")
+		case r >= 0x80:
+			b.WriteString("M-^")
+			b.WriteByte(byte(r - 0x80 + 0x40))
+		default:
+			b.WriteByte('^')
+			b.WriteByte(byte(r + 0x40))
+		}
+	}
+	return b.String()
+}
+
+func isTerminalControl(r rune) bool {
+	return (r < 0x20 && r != '\n' && r != '\t') || (r >= 0x7F && r <= 0x9F)
+}
@@ -0,0 +1,103 @@
+package goftfy
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// decodeUTF7 decodes UTF-7 shift sequences (RFC 2152) left in text, such as
+// "+AOk-" for "é". A shift sequence is '+', a run of modified base64 holding
+// UTF-16BE code units, and an optional '-' that is absorbed; "+-" stands for
+// a literal '+'. Because '+' also appears in ordinary text ("+1 555 0100",
+// "C++"), a run is only decoded when it is well formed: its padding bits are
+// zero, it contains a non-digit, and it yields complete surrogate pairs of
+// printable, non-ASCII characters. Other runs are left untouched.
+func decodeUTF7(text string) string {
+	if !strings.Contains(text, "+") {
+		return text
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	for i := 0; i < len(text); {
+		if text[i] != '+' {
+			b.WriteByte(text[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(text) && isUTF7Base64(text[j]) {
+			j++
+		}
+		if j == i+1 {
+			if j < len(text) && text[j] == '-' {
+				b.WriteByte('+')
+				i = j + 1
+				continue
+			}
+			b.WriteByte('+')
+			i++
+			continue
+		}
+		decoded, ok := decodeUTF7Run(text[i+1 : j])
+		if !ok {
+			b.WriteByte('+')
+			i++
+			continue
+		}
+		b.WriteString(decoded)
+		if j < len(text) && text[j] == '-' {
+			j++
+		}
+		i = j
+	}
+	return b.String()
+}
+
+func isUTF7Base64(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '+' || c == '/'
+}
+
+func utf7Base64Value(c byte) uint32 {
+	switch {
+	case c >= 'A' && c <= 'Z':
+		return uint32(c - 'A')
+	case c >= 'a' && c <= 'z':
+		return uint32(c-'a') + 26
+	case c >= '0' && c <= '9':
+		return uint32(c-'0') + 52
+	case c == '+':
+		return 62
+	}
+	return 63
+}
+
+// decodeUTF7Run decodes the modified base64 between '+' and the end of a
+// shift sequence, reporting false if it does not look like real UTF-7.
+func decodeUTF7Run(run string) (string, bool) {
+	if strings.Trim(run, "0123456789") == "" {
+		return "", false
+	}
+	var units []uint16
+	var acc uint32
+	bits := 0
+	for i := 0; i < len(run); i++ {
+		acc = acc<<6 | utf7Base64Value(run[i])
+		bits += 6
+		if bits >= 16 {
+			bits -= 16
+			units = append(units, uint16(acc>>bits))
+			acc &= 1<<bits - 1
+		}
+	}
+	if bits >= 6 || acc != 0 || len(units) == 0 {
+		return "", false
+	}
+	rs := utf16.Decode(units)
+	for _, r := range rs {
+		if r == unicode.ReplacementChar || r <= unicode.MaxASCII || !unicode.IsPrint(r) {
+			return "", false
+		}
+	}
+	return string(rs), true
+}
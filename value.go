@@ -0,0 +1,157 @@
+package goftfy
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FixValue applies the default fixes to every string reachable from v,
+// following pointers, interfaces, structs, slices, arrays and map values,
+// however deeply nested. It is meant for dynamically shaped data such as the
+// result of decoding gob or msgpack into interface{} values.
+//
+// Strings are replaced in place, so v must be settable wherever a string is
+// found directly: pass reflect.ValueOf(&x) rather than reflect.ValueOf(x)
+// for structs and arrays. Maps and slices are updated through their shared
+// backing storage. Map keys and unexported struct fields are left alone, and
+// cycles through pointers, maps or slices are followed only once. Struct
+// fields honor the goftfy tags described at FixStruct.
+func FixValue(v reflect.Value) error {
+	return fixValue(v, DefaultOptions(), make(map[visit]bool))
+}
+
+// FixStruct fixes every exported string field of the struct v points to,
+// following nested structs, pointers, slices, arrays and maps as FixValue
+// does, and returns an error if v is not a non-nil pointer. Fields are fixed
+// with the default options; a goftfy struct tag adjusts that per field:
+//
+//	Raw  string `goftfy:"-"`     // left untouched
+//	Body string `goftfy:"curly"` // curly quotes straightened too
+//
+// A tag applies to everything reachable through its field.
+func FixStruct(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("goftfy: FixStruct: need a non-nil pointer, got %T", v)
+	}
+	return fixValue(rv, DefaultOptions(), make(map[visit]bool))
+}
+
+// fieldOptions applies a field's goftfy tag to opts, reporting false for
+// fields tagged "-".
+func fieldOptions(f reflect.StructField, opts Options) (Options, bool, error) {
+	tag, ok := f.Tag.Lookup("goftfy")
+	if !ok {
+		return opts, true, nil
+	}
+	for _, name := range strings.Split(tag, ",") {
+		switch name {
+		case "-":
+			return opts, false, nil
+		case "curly":
+			opts.FixCurlyQuotes = true
+		case "":
+		default:
+			return opts, false, fmt.Errorf("goftfy: field %s: unknown tag option %q", f.Name, name)
+		}
+	}
+	return opts, true, nil
+}
+
+// visit identifies a pointer, map or slice fixValue has already entered, so
+// that data containing itself is walked only once. A slice is identified by
+// its length as well as its backing array, since a shorter slice of the same
+// array covers fewer elements.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// enter reports whether v is seen for the first time, recording it.
+func enter(v reflect.Value, seen map[visit]bool) bool {
+	key := visit{v.Pointer(), v.Type(), 0}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	if seen[key] {
+		return false
+	}
+	seen[key] = true
+	return true
+}
+
+func fixValue(v reflect.Value, opts Options, seen map[visit]bool) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !v.CanSet() {
+			return fmt.Errorf("goftfy: FixValue: cannot set string of type %s; pass a pointer", v.Type())
+		}
+		if fixed := FixWithOptions(v.String(), opts.fragment()); fixed != v.String() {
+			v.SetString(fixed)
+		}
+	case reflect.Pointer:
+		if v.IsNil() || !enter(v, seen) {
+			return nil
+		}
+		return fixValue(v.Elem(), opts, seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		inner := v.Elem()
+		if inner.Kind() == reflect.Pointer || inner.Kind() == reflect.Map || inner.Kind() == reflect.Slice {
+			return fixValue(inner, opts, seen)
+		}
+		if !v.CanSet() {
+			return fmt.Errorf("goftfy: FixValue: cannot set interface holding %s; pass a pointer", inner.Type())
+		}
+		cp := reflect.New(inner.Type()).Elem()
+		cp.Set(inner)
+		if err := fixValue(cp, opts, seen); err != nil {
+			return err
+		}
+		v.Set(cp)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			fieldOpts, ok, err := fieldOptions(t.Field(i), opts)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if err := fixValue(v.Field(i), fieldOpts, seen); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && (v.Len() == 0 || !enter(v, seen)) {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := fixValue(v.Index(i), opts, seen); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() || !enter(v, seen) {
+			return nil
+		}
+		for _, k := range v.MapKeys() {
+			val := v.MapIndex(k)
+			cp := reflect.New(val.Type()).Elem()
+			cp.Set(val)
+			if err := fixValue(cp, opts, seen); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, cp)
+		}
+	}
+	return nil
+}